@@ -16,19 +16,13 @@
 package Middleware
 
 import (
-	"crypto/ecdsa"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
 
 	"github.com/ezbastion/ezb_vault/configuration"
 
 	"net/http"
-	"os"
-	"path"
 	"strings"
 
 	jwt "github.com/dgrijalva/jwt-go"
@@ -45,10 +39,13 @@ type Payload struct {
 	AUD string `json:"aud"`
 	EXP int    `json:"exp"`
 	IAT int    `json:"iat"`
+	// Once flag a one-time-use token, checked when conf.OneTimeUse is set.
+	Once bool `json:"once"`
 }
 
-func AuthJWT(db *gorm.DB, conf configuration.Configuration) gin.HandlerFunc {
+func AuthJWT(db *gorm.DB, live *configuration.Live, rl *RevocationList, keys *IssuerKeys) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		conf := live.Get()
 
 		logmanager.WithFields("Middleware", "jwt")
 		var err error
@@ -71,7 +68,8 @@ func AuthJWT(db *gorm.DB, conf configuration.Configuration) gin.HandlerFunc {
 			c.AbortWithError(http.StatusForbidden, errors.New("#V0012"))
 			return
 		}
-		p, err := base64.RawStdEncoding.DecodeString(parts[1])
+		// the segments are base64url (RFC 7519), without padding
+		p, err := jwt.DecodeSegment(parts[1])
 		if err != nil {
 			logmanager.Error(fmt.Sprintf("Unable to decode payload: %v", err.Error()))
 			c.AbortWithError(http.StatusForbidden, errors.New("#V0009"))
@@ -84,27 +82,16 @@ func AuthJWT(db *gorm.DB, conf configuration.Configuration) gin.HandlerFunc {
 			c.AbortWithError(http.StatusForbidden, errors.New("#V0011"))
 			return
 		}
-		jwtkeyfile := fmt.Sprintf("%s.crt", payload.ISS)
-		// change the path to the configuration file
-		// TODO check for cert name itself, in the payload
-		ex, _ := os.Executable()
-		exPath := filepath.Dir(ex)
-		jwtpubkey := path.Join(exPath, "cert", jwtkeyfile)
-		// jwtpubkey := path.Join(conf.StaPath, jwtkeyfile)
-		// logmanager.Debug(fmt.Sprintf("##### sta public certificate set to %s", conf.StaPath))
-		logmanager.Debug(fmt.Sprintf("sta public certificate set to %s", jwtpubkey))
-
-		if _, err := os.Stat(jwtpubkey); os.IsNotExist(err) {
-			logmanager.Error(fmt.Sprintf("Unable to load sta public certificate: %v", err.Error()))
+		ecdsaKey, err := keys.Get(payload.ISS)
+		if err == ErrUnknownIssuer {
+			logmanager.Error(fmt.Sprintf("Unable to load sta public certificate: no %s.crt", payload.ISS))
 			c.AbortWithError(http.StatusForbidden, errors.New("#V0010"))
 			return
 		}
-
-		key, _ := ioutil.ReadFile(jwtpubkey)
-		var ecdsaKey *ecdsa.PublicKey
-		if ecdsaKey, err = jwt.ParseECPublicKeyFromPEM(key); err != nil {
+		if err != nil {
 			logmanager.Error(fmt.Sprintf("Unable to parse ECDSA public key: %v", err.Error()))
 			c.AbortWithError(http.StatusForbidden, errors.New("#V0003"))
+			return
 		}
 		methode := jwt.GetSigningMethod("ES256")
 		// parts := strings.Split(tokenString, ".")
@@ -117,16 +104,59 @@ func AuthJWT(db *gorm.DB, conf configuration.Configuration) gin.HandlerFunc {
 			if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
 				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 			}
-			return jwt.ParseECPublicKeyFromPEM(key)
+			return ecdsaKey, nil
 		})
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 			// log.Println(claims["iss"], claims["sub"])
 			c.Set("sub", claims["sub"])
+			c.Set("iss", payload.ISS)
+			c.Set("jti", payload.JTI)
+			c.Set("groups", groupsClaim(claims, conf.GroupClaim))
 		} else {
 			c.AbortWithError(http.StatusForbidden, errors.New("#V0005"))
 			logmanager.Error(err.Error())
 			return
 		}
+		if rl.IsRevoked(payload.JTI, payload.SUB) {
+			logmanager.Error(fmt.Sprintf("revoked token #V0013: jti:'%s' sub:'%s'", payload.JTI, payload.SUB))
+			c.AbortWithError(http.StatusForbidden, errors.New("#V0013"))
+			return
+		}
+		if conf.OneTimeUse && payload.Once {
+			if err := rl.Consume(payload.JTI, int64(payload.EXP)); err == ErrReplay {
+				logmanager.Error(fmt.Sprintf("one-time-use token rejected #V0014: jti:'%s' %s", payload.JTI, err.Error()))
+				c.AbortWithError(http.StatusForbidden, errors.New("#V0014"))
+				return
+			} else if err != nil {
+				logmanager.Error(fmt.Sprintf("one-time-use token not recorded #V0026: jti:'%s' %s", payload.JTI, err.Error()))
+				c.AbortWithError(http.StatusServiceUnavailable, errors.New("#V0026"))
+				return
+			}
+		}
 		c.Next()
 	}
 }
+
+// groupsClaim read the subject groups from the token, as a json array or a
+// comma separated string.
+func groupsClaim(claims jwt.MapClaims, name string) []string {
+	if name == "" {
+		name = "groups"
+	}
+	var groups []string
+	switch v := claims[name].(type) {
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok && s != "" {
+				groups = append(groups, s)
+			}
+		}
+	case string:
+		for _, g := range strings.Split(v, ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				groups = append(groups, g)
+			}
+		}
+	}
+	return groups
+}
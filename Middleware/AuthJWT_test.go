@@ -0,0 +1,87 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package Middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/gin-gonic/gin"
+)
+
+// The payload is base64url encoded (RFC 7519), a payload holding - or _ must
+// be accepted.
+func TestAuthJWTBase64URL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sta, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pub, _ := x509.MarshalPKIXPublicKey(&sta.PublicKey)
+	ioutil.WriteFile(filepath.Join(dir, "ezb_sta.crt"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0600)
+	db := testDB(t)
+	rl, err := NewRevocationList(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(AuthJWT(db, configuration.NewLive(configuration.Configuration{}, dir), rl, NewIssuerKeys(dir)))
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.GetString("sub")) })
+
+	tried := 0
+	for i := 0; i < 64 && tried < 2; i++ {
+		sub := fmt.Sprintf("user~%d?>", i)
+		token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"iss": "ezb_sta",
+			"sub": sub,
+			"jti": fmt.Sprint(i),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}).SignedString(sta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.ContainsAny(strings.Split(token, ".")[1], "-_") {
+			continue
+		}
+		tried++
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != sub {
+			t.Errorf("TestAuthJWTBase64URL was incorrect, got: <%d %s>, want: <%d %s>.", w.Code, w.Body, http.StatusOK, sub)
+		}
+	}
+	if tried == 0 {
+		t.Fatal("TestAuthJWTBase64URL made no payload with - or _")
+	}
+}
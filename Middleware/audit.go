@@ -0,0 +1,166 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package Middleware
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ezbastion/ezb_lib/logmanager"
+	"github.com/ezbastion/ezb_vault/audit"
+	"github.com/gin-gonic/gin"
+)
+
+// actions name the key/value routes in the audit log, other routes are
+// named after their method and path.
+var actions = map[string]string{
+	"GET /":                    "list",
+	"GET /:name":               "read",
+	"POST /":                   "create",
+	"PUT /:name":               "update",
+	"DELETE /:name":            "delete",
+	"GET /:name/versions":      "versions",
+	"GET /:name/shares":        "list-shares",
+	"POST /:name/shares":       "share",
+	"DELETE /:name/shares/:id": "unshare",
+	"GET /sys/export":          "export",
+	"POST /sys/import":         "import",
+}
+
+// AuditMiddleware write one audit record per request, once the handlers are done.
+// It must be set before AuthJWT, to record rejected tokens too. Handlers can
+// refine the record with the audit.action, audit.key and audit.error keys.
+// When the logger is fail closed, the response is held until the record is
+// written, and replaced by a 503 if no sink accepted it.
+func AuditMiddleware(l *audit.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		if !l.FailClosed() {
+			c.Next()
+			if err := l.Log(auditRecord(c)); err != nil {
+				logmanager.Error(fmt.Sprintf("unable to write audit record: %s", err.Error()))
+			}
+			return
+		}
+		w := &heldWriter{ResponseWriter: c.Writer, status: http.StatusOK, size: -1}
+		c.Writer = w
+		c.Next()
+		// the status is the held one, the real writer still has none
+		r := auditRecord(c)
+		c.Writer = w.ResponseWriter
+		if err := l.Log(r); err != nil {
+			logmanager.Error(fmt.Sprintf("unable to write audit record, request refused: %s", err.Error()))
+			c.Header("Content-Length", "")
+			c.JSON(http.StatusServiceUnavailable, "#V0019")
+			return
+		}
+		w.flush()
+	}
+}
+
+func auditRecord(c *gin.Context) audit.Record {
+	route := c.FullPath()
+	if route == "" {
+		route = c.Request.URL.Path
+	}
+	action := c.GetString("audit.action")
+	if action == "" {
+		if action = actions[c.Request.Method+" "+route]; action == "" {
+			action = strings.ToLower(c.Request.Method) + " " + route
+		}
+	}
+	key := c.GetString("audit.key")
+	if key == "" {
+		key = c.Param("name")
+	}
+	r := audit.Record{
+		Sub:    c.GetString("sub"),
+		Iss:    c.GetString("iss"),
+		Jti:    c.GetString("jti"),
+		IP:     remoteIP(c),
+		Owner:  c.GetString("owner"),
+		Key:    key,
+		Action: action,
+		Status: c.Writer.Status(),
+		Result: "success",
+		Error:  c.GetString("audit.error"),
+	}
+	if r.Status >= 400 {
+		r.Result = "failure"
+	}
+	if r.Error == "" && len(c.Errors) > 0 {
+		r.Error = c.Errors.Last().Error()
+	}
+	return r
+}
+
+// heldWriter keep the status and body in memory, nothing is sent to the
+// client before flush.
+type heldWriter struct {
+	gin.ResponseWriter
+	status int
+	size   int
+	body   bytes.Buffer
+}
+
+func (w *heldWriter) WriteHeader(code int) {
+	if code > 0 && w.size == -1 {
+		w.status = code
+	}
+}
+
+func (w *heldWriter) WriteHeaderNow() {
+	if w.size == -1 {
+		w.size = 0
+	}
+}
+
+func (w *heldWriter) Write(b []byte) (int, error) {
+	w.WriteHeaderNow()
+	n, err := w.body.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *heldWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *heldWriter) Status() int {
+	return w.status
+}
+
+func (w *heldWriter) Size() int {
+	return w.size
+}
+
+func (w *heldWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *heldWriter) flush() {
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.body.Len() > 0 {
+		w.ResponseWriter.Write(w.body.Bytes())
+	} else {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
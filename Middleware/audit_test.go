@@ -0,0 +1,76 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package Middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ezbastion/ezb_vault/audit"
+	"github.com/gin-gonic/gin"
+)
+
+// A request refused by a handler is audited with its status, also when the
+// response is held by a fail closed logger.
+func TestAuditStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, failClosed := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "audit")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "audit.log")
+		f, err := audit.NewFileSink(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := audit.New([]audit.Sink{f}, failClosed)
+		r := gin.New()
+		r.Use(AuditMiddleware(l))
+		r.GET("/:name", func(c *gin.Context) {
+			c.AbortWithError(http.StatusForbidden, errors.New("#V0015"))
+		})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/db-pass", nil)
+		req.RemoteAddr = "10.0.0.1:40000"
+		req.Header.Set("X-Forwarded-For", "192.0.2.9")
+		r.ServeHTTP(w, req)
+		l.Close()
+		if w.Code != http.StatusForbidden {
+			t.Errorf("TestAuditStatus failclosed=%t answer was incorrect, got: <%d>, want: <%d>.", failClosed, w.Code, http.StatusForbidden)
+		}
+		b, _ := ioutil.ReadFile(path)
+		var rec audit.Record
+		if err := json.Unmarshal([]byte(strings.TrimSpace(string(b))), &rec); err != nil {
+			t.Fatalf("TestAuditStatus unreadable record <%s>: %v", b, err)
+		}
+		if rec.Status != http.StatusForbidden || rec.Result != "failure" || rec.Key != "db-pass" {
+			t.Errorf("TestAuditStatus failclosed=%t was incorrect, got: <%d %s %s>, want: <%d %s %s>.", failClosed, rec.Status, rec.Result, rec.Key, http.StatusForbidden, "failure", "db-pass")
+		}
+		// X-Forwarded-For is set by the client, it is not trusted
+		if rec.IP != "10.0.0.1" {
+			t.Errorf("TestAuditStatus ip was incorrect, got: <%s>, want: <%s>.", rec.IP, "10.0.0.1")
+		}
+	}
+}
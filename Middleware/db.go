@@ -16,6 +16,7 @@
 package Middleware
 
 import (
+	"github.com/ezbastion/ezb_vault/configuration"
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
 )
@@ -26,3 +27,11 @@ func DBMiddleware(db *gorm.DB) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// ConfMiddleware give the handlers the running configuration.
+func ConfMiddleware(live *configuration.Live) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("conf", live)
+		c.Next()
+	}
+}
@@ -0,0 +1,244 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package Middleware
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ezbastion/ezb_lib/logmanager"
+	"github.com/ezbastion/ezb_vault/audit"
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/gin-gonic/gin"
+)
+
+// bucket is a token bucket, refilled at rate tokens per second up to burst.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) allow(now time.Time, rate float64, burst int) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Lockout is a subject or an address locked after too many wrong keys.
+type Lockout struct {
+	Key   string    `json:"key"`
+	Until time.Time `json:"until"`
+}
+
+// Guard rate limit the requests per address and per subject, and lock a
+// subject out after too many failed decryptions. Many users may share an
+// address behind a NAT or a proxy, so it has its own, higher, threshold.
+type Guard struct {
+	mu       sync.Mutex
+	live     *configuration.Live
+	audit    *audit.Logger
+	now      func() time.Time
+	buckets  map[string]*bucket
+	failures map[string][]time.Time
+	locked   map[string]time.Time
+}
+
+func NewGuard(live *configuration.Live, al *audit.Logger) *Guard {
+	return &Guard{
+		live:     live,
+		audit:    al,
+		now:      time.Now,
+		buckets:  make(map[string]*bucket),
+		failures: make(map[string][]time.Time),
+		locked:   make(map[string]time.Time),
+	}
+}
+
+// remoteIP return the peer address, X-Forwarded-For can not be trusted to
+// count or restrict clients.
+func remoteIP(c *gin.Context) string {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
+
+// check return 429 if key is locked out or over its rate.
+func (g *Guard) check(c *gin.Context, key string, rate float64, burst int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	if until, ok := g.locked[key]; ok && now.Before(until) {
+		logmanager.Error(fmt.Sprintf("locked out #V0021: %s until %s", key, until.Format(time.RFC3339)))
+		c.Header("Retry-After", strconv.Itoa(int(until.Sub(now).Seconds())+1))
+		c.AbortWithError(http.StatusTooManyRequests, errors.New("#V0021"))
+		return false
+	}
+	if rate <= 0 {
+		return true
+	}
+	b, ok := g.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		g.buckets[key] = b
+	}
+	if !b.allow(now, rate, burst) {
+		logmanager.Warning(fmt.Sprintf("rate limited #V0020: %s", key))
+		c.Header("Retry-After", "1")
+		c.AbortWithError(http.StatusTooManyRequests, errors.New("#V0020"))
+		return false
+	}
+	return true
+}
+
+// IPLimit limit the requests per client address, set it before AuthJWT so
+// token guessing is limited too.
+func (g *Guard) IPLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l := g.live.Get().Limits
+		if !g.check(c, "ip:"+remoteIP(c), l.IPRate, l.IPBurstSize()) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// SubjectLimit limit the requests per token subject, set it after AuthJWT.
+// Handlers report wrong keys with Failed.
+func (g *Guard) SubjectLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l := g.live.Get().Limits
+		if !g.check(c, "sub:"+c.GetString("sub"), l.Rate, l.BurstSize()) {
+			return
+		}
+		c.Set("guard", g)
+		c.Next()
+	}
+}
+
+// Failed count a failed decryption of the request, the subject is locked
+// out when it reach MaxFailures in FailureWindow, the address when it reach
+// IPMaxFailures.
+func (g *Guard) Failed(c *gin.Context) {
+	l := g.live.Get().Limits
+	if l.MaxFailures < 0 {
+		return
+	}
+	sub, ip := c.GetString("sub"), remoteIP(c)
+	threshold := map[string]int{"sub:" + sub: l.MaxFails()}
+	if l.IPMaxFailures > 0 {
+		threshold["ip:"+ip] = l.IPMaxFailures
+	}
+	g.mu.Lock()
+	now := g.now()
+	var locked []string
+	for key, n := range threshold {
+		recent := g.failures[key][:0]
+		for _, t := range g.failures[key] {
+			if now.Sub(t) < l.Window() {
+				recent = append(recent, t)
+			}
+		}
+		recent = append(recent, now)
+		g.failures[key] = recent
+		if len(recent) >= n {
+			g.locked[key] = now.Add(l.LockoutDuration())
+			delete(g.failures, key)
+			locked = append(locked, key)
+		}
+	}
+	g.mu.Unlock()
+	for _, key := range locked {
+		logmanager.Error(fmt.Sprintf("%s locked out for %s after %d failed decryptions", key, l.LockoutDuration(), threshold[key]))
+		if g.audit == nil {
+			continue
+		}
+		err := g.audit.Log(audit.Record{
+			Sub:    sub,
+			IP:     ip,
+			Key:    key,
+			Action: "system:lockout",
+			Status: http.StatusTooManyRequests,
+			Result: "failure",
+			Error:  fmt.Sprintf("%d failed decryptions", threshold[key]),
+		})
+		if err != nil {
+			logmanager.Error(fmt.Sprintf("unable to write audit record: %s", err.Error()))
+		}
+	}
+}
+
+// Lockouts return the active lockouts.
+func (g *Guard) Lockouts() []Lockout {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	out := []Lockout{}
+	for key, until := range g.locked {
+		if now.Before(until) {
+			out = append(out, Lockout{Key: key, Until: until})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// Unlock remove a lockout, key is sub:<subject> or ip:<address>.
+func (g *Guard) Unlock(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.locked[key]
+	delete(g.locked, key)
+	delete(g.failures, key)
+	return ok
+}
+
+// Purge drop the idle buckets, old failures and ended lockouts.
+func (g *Guard) Purge() {
+	l := g.live.Get().Limits
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	for key, b := range g.buckets {
+		if now.Sub(b.last) > 10*time.Minute {
+			delete(g.buckets, key)
+		}
+	}
+	for key, fs := range g.failures {
+		if len(fs) == 0 || now.Sub(fs[len(fs)-1]) > l.Window() {
+			delete(g.failures, key)
+		}
+	}
+	for key, until := range g.locked {
+		if !now.Before(until) {
+			delete(g.locked, key)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package Middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/gin-gonic/gin"
+)
+
+// clock is a settable time for the guard.
+type clock struct{ t time.Time }
+
+func (k *clock) now() time.Time      { return k.t }
+func (k *clock) add(d time.Duration) { k.t = k.t.Add(d) }
+func testGuard(l configuration.LimitConf) (*Guard, *clock) {
+	k := &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	g := NewGuard(configuration.NewLive(configuration.Configuration{Limits: l}, ""), nil)
+	g.now = k.now
+	return g, k
+}
+
+// request run one request through IPLimit and SubjectLimit, as sub from
+// 10.0.0.1. With fail, the handler report a wrong key.
+func request(g *Guard, sub string, fail bool) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(g.IPLimit(), func(c *gin.Context) { c.Set("sub", sub) }, g.SubjectLimit())
+	r.GET("/", func(c *gin.Context) {
+		if fail {
+			g.Failed(c)
+		}
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:40000"
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestBucket(t *testing.T) {
+	g, k := testGuard(configuration.LimitConf{Rate: 1, Burst: 2})
+	for i, want := range []int{200, 200, 429} {
+		if w := request(g, "alice", false); w.Code != want {
+			t.Errorf("TestBucket request %d was incorrect, got: <%d>, want: <%d>.", i, w.Code, want)
+		}
+	}
+	if w := request(g, "alice", false); w.Header().Get("Retry-After") != "1" {
+		t.Errorf("TestBucket was incorrect, got: <%s>, want: <%s>.", w.Header().Get("Retry-After"), "1")
+	}
+	// another subject has its own bucket
+	if w := request(g, "bob", false); w.Code != 200 {
+		t.Errorf("TestBucket was incorrect, got: <%d>, want: <%d>.", w.Code, 200)
+	}
+	k.add(time.Second)
+	if w := request(g, "alice", false); w.Code != 200 {
+		t.Errorf("TestBucket refill was incorrect, got: <%d>, want: <%d>.", w.Code, 200)
+	}
+	if w := request(g, "alice", false); w.Code != 429 {
+		t.Errorf("TestBucket refill was incorrect, got: <%d>, want: <%d>.", w.Code, 429)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	g, k := testGuard(configuration.LimitConf{MaxFailures: 3, FailureWindow: 60, Lockout: 600})
+	request(g, "alice", true)
+	request(g, "alice", true)
+	// the first failures are out of the window
+	k.add(61 * time.Second)
+	request(g, "alice", true)
+	request(g, "alice", true)
+	if w := request(g, "alice", false); w.Code != 200 {
+		t.Fatalf("TestLockout window was incorrect, got: <%d>, want: <%d>.", w.Code, 200)
+	}
+	request(g, "alice", true)
+	w := request(g, "alice", false)
+	if w.Code != 429 || w.Header().Get("Retry-After") != "601" {
+		t.Errorf("TestLockout was incorrect, got: <%d %s>, want: <%d %s>.", w.Code, w.Header().Get("Retry-After"), 429, "601")
+	}
+	// the address is shared, other subjects behind it are not locked out
+	if w := request(g, "bob", false); w.Code != 200 {
+		t.Errorf("TestLockout was incorrect, got: <%d>, want: <%d>.", w.Code, 200)
+	}
+	if l := g.Lockouts(); len(l) != 1 || l[0].Key != "sub:alice" {
+		t.Errorf("TestLockout was incorrect, got: <%v>, want: <%s>.", l, "sub:alice")
+	}
+	if !g.Unlock("sub:alice") || g.Unlock("sub:alice") {
+		t.Errorf("TestLockout Unlock was incorrect.")
+	}
+	if w := request(g, "alice", false); w.Code != 200 {
+		t.Errorf("TestLockout Unlock was incorrect, got: <%d>, want: <%d>.", w.Code, 200)
+	}
+	request(g, "alice", true)
+	k.add(10 * time.Minute)
+	g.Purge()
+	if len(g.failures) != 0 || len(g.locked) != 0 {
+		t.Errorf("TestLockout Purge was incorrect, got: <%v %v>, want: <empty>.", g.failures, g.locked)
+	}
+}
+
+func TestIPLockout(t *testing.T) {
+	g, _ := testGuard(configuration.LimitConf{MaxFailures: 2, IPMaxFailures: 3})
+	request(g, "alice", true)
+	request(g, "bob", true)
+	if w := request(g, "carol", false); w.Code != 200 {
+		t.Errorf("TestIPLockout was incorrect, got: <%d>, want: <%d>.", w.Code, 200)
+	}
+	request(g, "carol", true)
+	w := request(g, "dave", false)
+	if w.Code != 429 || w.Header().Get("Retry-After") == "" {
+		t.Errorf("TestIPLockout was incorrect, got: <%d %s>, want: <%d Retry-After>.", w.Code, w.Header().Get("Retry-After"), 429)
+	}
+	if !g.Unlock("ip:10.0.0.1") {
+		t.Errorf("TestIPLockout Unlock was incorrect, got: <%v>, want: <%v>.", false, true)
+	}
+}
@@ -15,11 +15,132 @@
 
 package Middleware
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
 
-func AddHeaders(c *gin.Context) {
-	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, authorization")
-	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/gin-gonic/gin"
+)
+
+// CORS answer the browser preflights and set the CORS headers for the
+// configured origins only. Without origin, CORS is disabled: no header is set
+// and preflights are refused.
+func CORS(live *configuration.Live) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		conf := live.Get().CORS
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if len(conf.Origins) > 0 {
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+		allowed, wildcard := conf.Allow(origin)
+		if !allowed {
+			if preflight {
+				c.AbortWithError(http.StatusForbidden, errors.New("#V0022"))
+				return
+			}
+			c.Next()
+			return
+		}
+		h := c.Writer.Header()
+		// a "*" origin never get the credentials, any site could read the
+		// answers with the user cookies or certificate.
+		if wildcard {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+			if conf.Credentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		if !preflight {
+			if len(conf.Expose) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(conf.Expose, ", "))
+			}
+			c.Next()
+			return
+		}
+		method := strings.ToUpper(c.GetHeader("Access-Control-Request-Method"))
+		if !contains(conf.AllowedMethods(), method) {
+			c.AbortWithError(http.StatusForbidden, errors.New("#V0022"))
+			return
+		}
+		for _, rh := range strings.Split(c.GetHeader("Access-Control-Request-Headers"), ",") {
+			if rh = strings.TrimSpace(rh); rh != "" && !contains(conf.AllowedHeaders(), rh) {
+				c.AbortWithError(http.StatusForbidden, errors.New("#V0022"))
+				return
+			}
+		}
+		h.Set("Access-Control-Allow-Methods", strings.Join(conf.AllowedMethods(), ", "))
+		h.Set("Access-Control-Allow-Headers", strings.Join(conf.AllowedHeaders(), ", "))
+		if conf.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(conf.MaxAge))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// contains compare case insensitive, header and method names.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorBody write the #V code of a request aborted without answer, so the
+// clients get the reason and not only the status.
+func ErrorBody(c *gin.Context) {
+	c.Next()
+	err := c.Errors.Last()
+	if err == nil || c.Writer.Size() > 0 || c.Writer.Status() < http.StatusBadRequest || c.Request.Method == http.MethodHead {
+		return
+	}
+	if code := err.Error(); strings.HasPrefix(code, "#V") {
+		c.Writer.WriteString(strconv.Quote(code))
+	}
+}
+
+// SecurityHeaders forbid caching, sniffing and framing of the answers, and
+// pin https.
+func SecurityHeaders(c *gin.Context) {
+	h := c.Writer.Header()
+	h.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+	h.Set("Cache-Control", "no-store")
+	h.Set("Pragma", "no-cache")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	h.Set("Referrer-Policy", "no-referrer")
 	c.Next()
 }
+
+// BodyLimit refuse a body over MaxBody with 413. A body without length is
+// cut at the limit, handlers check the read error with TooLarge.
+func BodyLimit(live *configuration.Live) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		max := live.Get().MaxBodySize()
+		if c.Request.ContentLength > max {
+			c.AbortWithError(http.StatusRequestEntityTooLarge, errors.New("#V0023"))
+			return
+		}
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max)
+		}
+		c.Next()
+	}
+}
+
+// TooLarge tell if err come from a body cut by BodyLimit.
+func TooLarge(err error) bool {
+	return errors.As(err, new(*http.MaxBytesError))
+}
@@ -0,0 +1,119 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package Middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORSCredentials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		origins []string
+		origin  string
+		want    string
+		creds   string
+	}{
+		{[]string{"https://portal.local"}, "https://portal.local", "https://portal.local", "true"},
+		{[]string{"*"}, "https://evil.local", "*", ""},
+		{[]string{"https://portal.local", "*"}, "https://portal.local", "https://portal.local", "true"},
+	}
+	for _, tt := range tests {
+		live := configuration.NewLive(configuration.Configuration{CORS: configuration.CORSConf{Origins: tt.origins, Credentials: true}}, "")
+		r := gin.New()
+		r.Use(CORS(live))
+		r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Origin", tt.origin)
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("TestCORSCredentials %v was incorrect, got: <%s>, want: <%s>.", tt.origins, got, tt.want)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.creds {
+			t.Errorf("TestCORSCredentials %v was incorrect, got: <%s>, want: <%s>.", tt.origins, got, tt.creds)
+		}
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	live := configuration.NewLive(configuration.Configuration{MaxBody: 16}, "")
+	r := gin.New()
+	r.Use(ErrorBody, BodyLimit(live))
+	r.POST("/", func(c *gin.Context) {
+		var v map[string]string
+		if err := c.ShouldBindJSON(&v); TooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, "#V0023")
+			return
+		}
+		c.JSON(http.StatusOK, v)
+	})
+	tests := []struct {
+		body    string
+		chunked bool
+		want    int
+	}{
+		{`{"k":"v"}`, false, http.StatusOK},
+		{`{"k":"` + strings.Repeat("x", 32) + `"}`, false, http.StatusRequestEntityTooLarge},
+		// without length the body is cut at the limit, the handler see it
+		{`{"k":"v"}`, true, http.StatusOK},
+		{`{"k":"` + strings.Repeat("x", 32) + `"}`, true, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		if tt.chunked {
+			req.ContentLength = -1
+		}
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want || (tt.want == http.StatusRequestEntityTooLarge && !strings.Contains(w.Body.String(), "#V0023")) {
+			t.Errorf("TestBodyLimit chunked=%t was incorrect, got: <%d %s>, want: <%d>.", tt.chunked, w.Code, w.Body, tt.want)
+		}
+	}
+	if TooLarge(io.ErrUnexpectedEOF) {
+		t.Errorf("TestBodyLimit was incorrect, got: <%t> for another error, want: <false>.", true)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(SecurityHeaders)
+	r.GET("/", func(c *gin.Context) { c.JSON(http.StatusOK, "ok") })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	for h, want := range map[string]string{
+		"Strict-Transport-Security": "max-age=",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Content-Security-Policy":   "default-src 'none'",
+		"Referrer-Policy":           "no-referrer",
+		"Cache-Control":             "no-store",
+	} {
+		if got := w.Header().Get(h); !strings.Contains(got, want) {
+			t.Errorf("TestSecurityHeaders %s was incorrect, got: <%s>, want: <%s>.", h, got, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package Middleware
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrUnknownIssuer is returned when no <iss>.crt is found in the cert folder.
+var ErrUnknownIssuer = errors.New("unknown issuer")
+
+// IssuerKeys cache the sta public keys read from the cert folder. Reset drop
+// them, so an added or replaced key is read again.
+type IssuerKeys struct {
+	mu   sync.RWMutex
+	dir  string
+	keys map[string]*ecdsa.PublicKey
+}
+
+func NewIssuerKeys(dir string) *IssuerKeys {
+	return &IssuerKeys{dir: dir, keys: make(map[string]*ecdsa.PublicKey)}
+}
+
+// Get return the public key of iss, read from <dir>/<iss>.crt the first time.
+func (k *IssuerKeys) Get(iss string) (*ecdsa.PublicKey, error) {
+	k.mu.RLock()
+	key, ok := k.keys[iss]
+	k.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if iss == "" || filepath.Base(iss) != iss {
+		return nil, ErrUnknownIssuer
+	}
+	raw, err := ioutil.ReadFile(path.Join(k.dir, iss+".crt"))
+	if os.IsNotExist(err) {
+		return nil, ErrUnknownIssuer
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key, err = jwt.ParseECPublicKeyFromPEM(raw); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	k.keys[iss] = key
+	k.mu.Unlock()
+	return key, nil
+}
+
+// Reset drop the cached keys.
+func (k *IssuerKeys) Reset() {
+	k.mu.Lock()
+	k.keys = make(map[string]*ecdsa.PublicKey)
+	k.mu.Unlock()
+}
@@ -0,0 +1,83 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package Middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A key is cached until Reset, then the replaced sta key or certificate is
+// read again.
+func TestIssuerKeysReset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ezb_sta.crt")
+	first, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pub, _ := x509.MarshalPKIXPublicKey(&first.PublicKey)
+	ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0600)
+
+	keys := NewIssuerKeys(dir)
+	if _, err := keys.Get("ezb_other"); err != ErrUnknownIssuer {
+		t.Errorf("TestIssuerKeysReset unknown was incorrect, got: <%v>, want: <%v>.", err, ErrUnknownIssuer)
+	}
+	key, err := keys.Get("ezb_sta")
+	if err != nil || !key.Equal(&first.PublicKey) {
+		t.Fatalf("TestIssuerKeysReset was incorrect, got: <%v>, want the first key.", err)
+	}
+
+	// the sta is renewed, its key now in a certificate
+	second, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "ezb_sta"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &second.PublicKey, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if key, _ := keys.Get("ezb_sta"); !key.Equal(&first.PublicKey) {
+		t.Errorf("TestIssuerKeysReset cache was incorrect, got another key, want the first key.")
+	}
+	keys.Reset()
+	key, err = keys.Get("ezb_sta")
+	if err != nil || !key.Equal(&second.PublicKey) {
+		t.Errorf("TestIssuerKeysReset was incorrect, got: <%v>, want the second key.", err)
+	}
+
+	// a removed sta is unknown after Reset
+	os.Remove(file)
+	keys.Reset()
+	if _, err := keys.Get("ezb_sta"); err != ErrUnknownIssuer {
+		t.Errorf("TestIssuerKeysReset removed was incorrect, got: <%v>, want: <%v>.", err, ErrUnknownIssuer)
+	}
+}
@@ -0,0 +1,78 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package Middleware
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware count and time every request, and the #V codes of the
+// rejected ones. It must be set before AuthJWT.
+func MetricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	status := strconv.Itoa(c.Writer.Status())
+	metrics.Requests.WithLabelValues(c.Request.Method, route, status).Inc()
+	metrics.Duration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	for _, e := range c.Errors {
+		if code := e.Error(); strings.HasPrefix(code, "#V") {
+			metrics.Rejections.WithLabelValues(code).Inc()
+		}
+	}
+}
+
+// MetricsAllow restrict /sys/metrics to the client addresses in cidrs, any
+// address if empty. Invalid entries are ignored.
+func MetricsAllow(cidrs []string) gin.HandlerFunc {
+	var nets []*net.IPNet
+	for _, s := range cidrs {
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		if _, n, err := net.ParseCIDR(s); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return func(c *gin.Context) {
+		if len(cidrs) == 0 {
+			c.Next()
+			return
+		}
+		ip := net.ParseIP(remoteIP(c))
+		for _, n := range nets {
+			if ip != nil && n.Contains(ip) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatus(http.StatusForbidden)
+	}
+}
@@ -0,0 +1,196 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package Middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ezbastion/ezb_lib/logmanager"
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/ezbastion/ezb_vault/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// PolicyStore is an in memory copy of the policy table.
+type PolicyStore struct {
+	mu       sync.RWMutex
+	db       *gorm.DB
+	policies []models.Policy
+}
+
+// NewPolicyStore build the store and load it from the database.
+func NewPolicyStore(db *gorm.DB) (*PolicyStore, error) {
+	ps := &PolicyStore{db: db}
+	if err := ps.Load(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// Load replace the cache content with the database one.
+func (ps *PolicyStore) Load() error {
+	var policies []models.Policy
+	if err := ps.db.Find(&policies).Error; err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	ps.policies = policies
+	ps.mu.Unlock()
+	return nil
+}
+
+// List return a copy of all policies.
+func (ps *PolicyStore) List() []models.Policy {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return append([]models.Policy(nil), ps.policies...)
+}
+
+// Save create or update a policy.
+func (ps *PolicyStore) Save(p *models.Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if err := ps.db.Save(p).Error; err != nil {
+		return err
+	}
+	return ps.Load()
+}
+
+// Delete remove a policy by id.
+func (ps *PolicyStore) Delete(id int) error {
+	if err := ps.db.Where("id = ?", id).Delete(models.Policy{}).Error; err != nil {
+		return err
+	}
+	return ps.Load()
+}
+
+// Allowed return true if sub, or one of its groups, hold capability on owner/key.
+// A subject has always every capability on its own secrets.
+func (ps *PolicyStore) Allowed(sub string, groups []string, owner, key, capability string) bool {
+	if owner == sub {
+		return true
+	}
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	for _, p := range ps.policies {
+		if p.AppliesTo(sub, groups) && p.Allow(owner, key, capability) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanList return true if sub, or one of its groups, may list some of owner secrets.
+func (ps *PolicyStore) CanList(sub string, groups []string, owner string) bool {
+	if owner == sub {
+		return true
+	}
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	for _, p := range ps.policies {
+		if p.AppliesTo(sub, groups) && p.Has(models.CapList) && models.Match(strings.SplitN(p.Pattern, "/", 2)[0], owner) {
+			return true
+		}
+	}
+	return false
+}
+
+func PolicyMiddleware(ps *PolicyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("policies", ps)
+		c.Next()
+	}
+}
+
+// ACL resolve the secret owner (?owner= or the token subject) and check the
+// capability needed by the route. The key of a POST is only known from the body,
+// so ctrl check it with the "acl" function set here.
+func ACL(c *gin.Context) {
+	ps, _ := c.MustGet("policies").(*PolicyStore)
+	sub := c.GetString("sub")
+	groups := c.GetStringSlice("groups")
+	owner := c.Query("owner")
+	if owner == "" {
+		owner = sub
+	}
+	c.Set("owner", owner)
+	c.Set("acl", func(key, capability string) bool {
+		return ps.Allowed(sub, groups, owner, key, capability)
+	})
+	name := c.Param("name")
+	allowed := true
+	switch c.Request.Method {
+	case http.MethodGet:
+		if name == "" {
+			allowed = ps.CanList(sub, groups, owner)
+		} else {
+			allowed = ps.Allowed(sub, groups, owner, name, models.CapRead) || hasShare(c, owner, name, sub, groups)
+		}
+	case http.MethodPut:
+		allowed = ps.Allowed(sub, groups, owner, name, models.CapWrite)
+	case http.MethodDelete:
+		allowed = ps.Allowed(sub, groups, owner, name, models.CapDelete)
+	}
+	if !allowed {
+		logmanager.Error(fmt.Sprintf("access denied #V0015: sub:'%s' owner:'%s' key:'%s' method:%s", sub, owner, name, c.Request.Method))
+		c.AbortWithError(http.StatusForbidden, errors.New("#V0015"))
+		return
+	}
+	c.Next()
+}
+
+// hasShare return true if owner/key has been shared with sub or one of its groups.
+func hasShare(c *gin.Context, owner, key, sub string, groups []string) bool {
+	db, _ := c.MustGet("db").(*gorm.DB)
+	_, _, err := models.FindShare(db, owner, key, sub, groups)
+	return err == nil
+}
+
+// IsAdmin return true if sub, or one of its groups, is listed in the configuration.
+func IsAdmin(conf configuration.Configuration, sub string, groups []string) bool {
+	for _, a := range conf.Admins {
+		if strings.EqualFold(a, sub) {
+			return true
+		}
+	}
+	for _, a := range conf.AdminGroups {
+		for _, g := range groups {
+			if strings.EqualFold(a, g) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Admin restrict a route group to the vault administrators.
+func Admin(live *configuration.Live) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sub := c.GetString("sub")
+		if !IsAdmin(live.Get(), sub, c.GetStringSlice("groups")) {
+			logmanager.Error(fmt.Sprintf("admin access denied #V0016: sub:'%s' path:%s", sub, c.Request.URL.Path))
+			c.AbortWithError(http.StatusForbidden, errors.New("#V0016"))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,162 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package Middleware
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// ErrReplay is returned by Consume when a one-time-use token is presented twice.
+var ErrReplay = errors.New("token already used")
+
+// RevocationList is an in memory copy of the revocation and used token tables,
+// so AuthJWT can check a token without a database round-trip.
+type RevocationList struct {
+	mu   sync.RWMutex
+	db   *gorm.DB
+	jti  map[string]bool
+	sub  map[string]bool
+	used map[string]int64
+}
+
+// NewRevocationList build the cache and load it from the database.
+func NewRevocationList(db *gorm.DB) (*RevocationList, error) {
+	rl := &RevocationList{db: db}
+	if err := rl.Load(); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+// Load replace the cache content with the database one. It's called at start
+// and on each tick, to catch revocations added by the cli.
+func (rl *RevocationList) Load() error {
+	var revs []models.Revocation
+	var used []models.UsedToken
+	if err := rl.db.Find(&revs).Error; err != nil {
+		return err
+	}
+	if err := rl.db.Where("exp > ?", time.Now().Unix()).Find(&used).Error; err != nil {
+		return err
+	}
+	jti := make(map[string]bool)
+	sub := make(map[string]bool)
+	for _, r := range revs {
+		if r.JTI != "" {
+			jti[r.JTI] = true
+		}
+		if r.SUB != "" {
+			sub[r.SUB] = true
+		}
+	}
+	u := make(map[string]int64)
+	for _, t := range used {
+		u[t.JTI] = t.EXP
+	}
+	rl.mu.Lock()
+	rl.jti, rl.sub, rl.used = jti, sub, u
+	rl.mu.Unlock()
+	return nil
+}
+
+// IsRevoked return true if the token id or its subject has been revoked.
+func (rl *RevocationList) IsRevoked(jti, sub string) bool {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return (jti != "" && rl.jti[jti]) || (sub != "" && rl.sub[sub])
+}
+
+// Revoke add a revocation to the database and the cache.
+func (rl *RevocationList) Revoke(r *models.Revocation) error {
+	if r.JTI == "" && r.SUB == "" {
+		return errors.New("jti or sub is mandatory")
+	}
+	if err := rl.db.Create(r).Error; err != nil {
+		return err
+	}
+	rl.mu.Lock()
+	if r.JTI != "" {
+		rl.jti[r.JTI] = true
+	}
+	if r.SUB != "" {
+		rl.sub[r.SUB] = true
+	}
+	rl.mu.Unlock()
+	return nil
+}
+
+// Remove delete a revocation by id and reload the cache.
+func (rl *RevocationList) Remove(id int) error {
+	if err := rl.db.Where("id = ?", id).Delete(models.Revocation{}).Error; err != nil {
+		return err
+	}
+	return rl.Load()
+}
+
+// Consume mark a one-time-use token as used. The unique index on jti make the
+// insert fail if another request, or another vault sharing the database, was faster.
+// Another database error is returned as is, the token can be used again.
+func (rl *RevocationList) Consume(jti string, exp int64) error {
+	if jti == "" {
+		return errors.New("one-time-use token without jti")
+	}
+	rl.mu.Lock()
+	if _, ok := rl.used[jti]; ok {
+		rl.mu.Unlock()
+		return ErrReplay
+	}
+	rl.used[jti] = exp
+	rl.mu.Unlock()
+	if err := rl.db.Create(&models.UsedToken{JTI: jti, EXP: exp}).Error; err != nil {
+		// the row is there if another vault consumed the token first, else
+		// the database failed and the token is still unused
+		var used models.UsedToken
+		if rl.db.Where("jti = ?", jti).First(&used).Error == nil {
+			return ErrReplay
+		}
+		rl.mu.Lock()
+		delete(rl.used, jti)
+		rl.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Purge remove expired used tokens, they can't be replayed anymore.
+func (rl *RevocationList) Purge() error {
+	now := time.Now().Unix()
+	rl.mu.Lock()
+	for jti, exp := range rl.used {
+		if exp <= now {
+			delete(rl.used, jti)
+		}
+	}
+	rl.mu.Unlock()
+	return rl.db.Where("exp <= ?", now).Delete(models.UsedToken{}).Error
+}
+
+func RevocationMiddleware(rl *RevocationList) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("revocations", rl)
+		c.Next()
+	}
+}
@@ -0,0 +1,125 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package Middleware
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/ezbastion/ezb_vault/models"
+	"github.com/jinzhu/gorm"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := configuration.InitDB(configuration.Configuration{DB: "test.db"}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	return db
+}
+
+func TestRevocationList(t *testing.T) {
+	db := testDB(t)
+	db.Create(&models.Revocation{JTI: "jti-db"})
+	rl, err := NewRevocationList(db)
+	if err != nil {
+		t.Fatalf("TestRevocationList load failed: %v", err)
+	}
+	if !rl.IsRevoked("jti-db", "alice") {
+		t.Errorf("TestRevocationList loaded jti was incorrect, got: <%v>, want: <%v>.", false, true)
+	}
+	if err := rl.Revoke(&models.Revocation{}); err == nil {
+		t.Errorf("TestRevocationList empty revocation was incorrect, got: <nil>, want: <error>.")
+	}
+	if err := rl.Revoke(&models.Revocation{SUB: "bob"}); err != nil {
+		t.Fatalf("TestRevocationList revoke failed: %v", err)
+	}
+	for _, tc := range []struct {
+		jti, sub string
+		want     bool
+	}{{"any", "bob", true}, {"jti-db", "", true}, {"other", "alice", false}, {"", "", false}} {
+		if got := rl.IsRevoked(tc.jti, tc.sub); got != tc.want {
+			t.Errorf("TestRevocationList IsRevoked(%s, %s) was incorrect, got: <%v>, want: <%v>.", tc.jti, tc.sub, got, tc.want)
+		}
+	}
+	// a revocation added by the cli is seen after a Load
+	db.Create(&models.Revocation{JTI: "jti-cli"})
+	rl.Load()
+	if !rl.IsRevoked("jti-cli", "") {
+		t.Errorf("TestRevocationList reload was incorrect, got: <%v>, want: <%v>.", false, true)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	db := testDB(t)
+	rl, _ := NewRevocationList(db)
+	exp := time.Now().Add(time.Hour).Unix()
+	if err := rl.Consume("", exp); err == nil {
+		t.Errorf("TestConsume without jti was incorrect, got: <nil>, want: <error>.")
+	}
+	if err := rl.Consume("once", exp); err != nil {
+		t.Fatalf("TestConsume failed: %v", err)
+	}
+	if err := rl.Consume("once", exp); err != ErrReplay {
+		t.Errorf("TestConsume replay was incorrect, got: <%v>, want: <%v>.", err, ErrReplay)
+	}
+	// another vault sharing the database
+	other, _ := NewRevocationList(db)
+	if err := other.Consume("once", exp); err != ErrReplay {
+		t.Errorf("TestConsume other vault was incorrect, got: <%v>, want: <%v>.", err, ErrReplay)
+	}
+	// a database failure is not a replay, and does not burn the token
+	db.DropTable(&models.UsedToken{})
+	if err := rl.Consume("transient", exp); err == nil || err == ErrReplay {
+		t.Errorf("TestConsume database error was incorrect, got: <%v>, want: <database error>.", err)
+	}
+	db.AutoMigrate(&models.UsedToken{})
+	if err := rl.Consume("transient", exp); err != nil {
+		t.Errorf("TestConsume after database error was incorrect, got: <%v>, want: <nil>.", err)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	db := testDB(t)
+	rl, _ := NewRevocationList(db)
+	rl.Consume("old", time.Now().Add(-time.Minute).Unix())
+	rl.Consume("new", time.Now().Add(time.Hour).Unix())
+	if err := rl.Purge(); err != nil {
+		t.Fatalf("TestPurge failed: %v", err)
+	}
+	var count int
+	db.Model(&models.UsedToken{}).Count(&count)
+	if count != 1 {
+		t.Errorf("TestPurge rows was incorrect, got: <%d>, want: <%d>.", count, 1)
+	}
+	if err := rl.Consume("old", time.Now().Add(time.Hour).Unix()); err != nil {
+		t.Errorf("TestPurge expired jti was incorrect, got: <%v>, want: <nil>.", err)
+	}
+	if err := rl.Consume("new", time.Now().Add(time.Hour).Unix()); err != ErrReplay {
+		t.Errorf("TestPurge live jti was incorrect, got: <%v>, want: <%v>.", err, ErrReplay)
+	}
+}
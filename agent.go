@@ -0,0 +1,220 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/client"
+	"github.com/urfave/cli"
+)
+
+// agentTemplate is a --template SRC=DEST, the text/template SRC rendered in DEST.
+type agentTemplate struct {
+	Source string
+	Dest   string
+}
+
+func parseTemplates(list []string) ([]agentTemplate, error) {
+	var out []agentTemplate
+	for _, t := range list {
+		i := strings.LastIndex(t, "=")
+		if i < 1 || i == len(t)-1 {
+			return nil, fmt.Errorf("invalid --template '%s', want SRC=DEST", t)
+		}
+		out = append(out, agentTemplate{Source: t[:i], Dest: t[i+1:]})
+	}
+	return out, nil
+}
+
+// renderTemplate execute the template file src, {{secret "key"}} is the value
+// of key read by get.
+func renderTemplate(src string, get func(string) (string, error)) ([]byte, error) {
+	raw, err := ioutil.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := template.New(filepath.Base(src)).Option("missingkey=error").Funcs(template.FuncMap{"secret": get}).Parse(string(raw))
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := tpl.Execute(&b, nil); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeIfChanged replace dest by data with perm, through a temporary file in
+// the same folder. It return false if dest already hold data with perm.
+func writeIfChanged(dest string, data []byte, perm os.FileMode) (bool, error) {
+	if old, err := ioutil.ReadFile(dest); err == nil && bytes.Equal(old, data) {
+		// windows keep only the read-only bit
+		if fi, err := os.Stat(dest); err == nil && (fi.Mode().Perm() == perm || runtime.GOOS == "windows") {
+			return false, nil
+		}
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest)+".")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), dest)
+}
+
+// agent render the templates with the secrets of a vault.
+type agent struct {
+	vault     *client.Client
+	templates []agentTemplate
+	perm      os.FileMode
+	command   string
+	log       *log.Logger
+}
+
+// render write the templates, a failing one keep its previous file. The
+// command is run when a file changed.
+func (a *agent) render(ctx context.Context) (changed bool, err error) {
+	// a secret used by several templates is read once per pass
+	cache := map[string]string{}
+	get := func(key string) (string, error) {
+		if v, ok := cache[key]; ok {
+			return v, nil
+		}
+		s, err := a.vault.Get(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		cache[key] = s.Value
+		return s.Value, nil
+	}
+	failed := 0
+	for _, t := range a.templates {
+		data, terr := renderTemplate(t.Source, get)
+		if terr == nil {
+			var c bool
+			if c, terr = writeIfChanged(t.Dest, data, a.perm); c && terr == nil {
+				a.log.Printf("%s rendered", t.Dest)
+				changed = true
+			}
+		}
+		if terr != nil {
+			a.log.Printf("%s: %v", t.Dest, terr)
+			failed++
+		}
+	}
+	if failed > 0 {
+		err = fmt.Errorf("%d template(s) failed", failed)
+	}
+	if changed && a.command != "" {
+		cmd := shellCommand(a.command)
+		cmd.Env = childEnv(os.Environ(), nil)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if cerr := cmd.Run(); cerr != nil {
+			a.log.Printf("command '%s' failed: %v", a.command, cerr)
+		}
+	}
+	return changed, err
+}
+
+// agentCommand render templates with secrets, again every interval.
+func agentCommand() cli.Command {
+	return cli.Command{
+		Name:  "agent",
+		Usage: "Render template files with secrets, and keep them up to date.",
+		Flags: clientFlags(
+			cli.StringSliceFlag{Name: "template, t", Usage: "SRC=DEST, render the text/template SRC in DEST, repeatable"},
+			cli.StringFlag{Name: "perms", Value: "0600", Usage: "mode of the rendered files"},
+			cli.DurationFlag{Name: "interval", Value: time.Minute, Usage: "time between two renders"},
+			cli.StringFlag{Name: "exec", Usage: "command run after a file changed"},
+			cli.BoolFlag{Name: "once", Usage: "render once and exit"},
+		),
+		Action: func(c *cli.Context) error {
+			templates, err := parseTemplates(c.StringSlice("template"))
+			if err != nil {
+				return kvExit(err)
+			}
+			if len(templates) == 0 {
+				return kvExit(fmt.Errorf("agent need at least one --template SRC=DEST"))
+			}
+			perm, err := strconv.ParseUint(c.String("perms"), 8, 32)
+			if err != nil || perm > 0777 {
+				return kvExit(fmt.Errorf("invalid --perms '%s'", c.String("perms")))
+			}
+			if c.Duration("interval") < time.Second {
+				return kvExit(fmt.Errorf("--interval must be 1s or more"))
+			}
+			v, err := newVaultClient(c, true)
+			if err != nil {
+				return kvExit(err)
+			}
+			a := &agent{
+				vault:     v,
+				templates: templates,
+				perm:      os.FileMode(perm),
+				command:   c.String("exec"),
+				log:       log.New(os.Stderr, "ezb_vault agent: ", log.LstdFlags),
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if c.Bool("once") {
+				_, err := a.render(ctx)
+				return kvExit(err)
+			}
+			quit := make(chan os.Signal, 1)
+			signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(quit)
+			tick := time.NewTicker(c.Duration("interval"))
+			defer tick.Stop()
+			for {
+				a.render(ctx)
+				select {
+				case <-quit:
+					return nil
+				case <-tick.C:
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,80 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParseTemplates(t *testing.T) {
+	list, err := parseTemplates([]string{"app.tpl=/etc/app/app.conf", `C:\tpl\a=b.tpl=C:\app\a.conf`})
+	if err != nil || len(list) != 2 || list[1].Source != `C:\tpl\a=b.tpl` || list[1].Dest != `C:\app\a.conf` {
+		t.Errorf("TestParseTemplates was incorrect, got: <%v %v>.", list, err)
+	}
+	for _, bad := range []string{"app.tpl", "=dest", "src="} {
+		if _, err := parseTemplates([]string{bad}); err == nil {
+			t.Errorf("TestParseTemplates %s was incorrect, got: <nil>, want: <error>.", bad)
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "agent")
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "app.tpl")
+	ioutil.WriteFile(src, []byte(`password={{secret "prod/sql/sa"}}`), 0600)
+	get := func(key string) (string, error) {
+		if key == "prod/sql/sa" {
+			return "s3cret", nil
+		}
+		return "", errors.New("secret not found")
+	}
+	out, err := renderTemplate(src, get)
+	if err != nil || string(out) != "password=s3cret" {
+		t.Errorf("TestRenderTemplate was incorrect, got: <%s %v>, want: <%s>.", out, err, "password=s3cret")
+	}
+	ioutil.WriteFile(src, []byte(`password={{secret "missing"}}`), 0600)
+	if _, err := renderTemplate(src, get); err == nil {
+		t.Errorf("TestRenderTemplate missing secret was incorrect, got: <nil>, want: <error>.")
+	}
+}
+
+func TestWriteIfChanged(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "agent")
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "app.conf")
+	for i, want := range []bool{true, false} {
+		changed, err := writeIfChanged(dest, []byte("a"), 0600)
+		if err != nil || changed != want {
+			t.Errorf("TestWriteIfChanged %d was incorrect, got: <%v %v>, want: <%v>.", i, changed, err, want)
+		}
+	}
+	if changed, err := writeIfChanged(dest, []byte("b"), 0600); err != nil || !changed {
+		t.Errorf("TestWriteIfChanged update was incorrect, got: <%v %v>, want: <%v>.", changed, err, true)
+	}
+	fi, _ := os.Stat(dest)
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Errorf("TestWriteIfChanged mode was incorrect, got: <%v>, want: <%v>.", fi.Mode().Perm(), os.FileMode(0600))
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("TestWriteIfChanged left %d files, want: <1>.", len(files))
+	}
+}
@@ -0,0 +1,74 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ezbastion/ezb_vault/audit"
+	"github.com/urfave/cli"
+)
+
+// auditCommand check the audit log hash chain.
+func auditCommand() cli.Command {
+	return cli.Command{
+		Name:  "audit",
+		Usage: "Audit log tools.",
+		Subcommands: []cli.Command{
+			{
+				Name:      "verify",
+				Usage:     "Detect modified, removed or truncated audit records.",
+				ArgsUsage: "[audit file, default from config.json]",
+				Flags: []cli.Flag{
+					cli.IntFlag{
+						Name:  "rotated",
+						Usage: "also verify the rotated files file.N ... file.1, N is the rotate sink maxfiles.",
+					},
+					cli.BoolFlag{
+						Name:  "partial",
+						Usage: "accept a first record chained to a removed one (oldest rotated file deleted).",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					file := c.Args().First()
+					rotated := c.Int("rotated")
+					if file == "" {
+						file = conf.AuditFile(exPath)
+						for _, s := range conf.Audit.Sinks {
+							if s.Type == "rotate" || s.Type == "file" {
+								file = audit.SinkPath(s.Path, conf, exPath)
+								if s.Type == "rotate" && !c.IsSet("rotated") {
+									rotated = s.MaxFiles
+								}
+								break
+							}
+						}
+					}
+					files := []string{file}
+					if rotated > 0 {
+						files = audit.RotatedFiles(file, rotated)
+					}
+					n, err := audit.Verify(c.Bool("partial"), files...)
+					if err != nil {
+						return cli.NewExitError(fmt.Sprintf("%s: %d valid records, then: %v", file, n, err), 1)
+					}
+					fmt.Printf("%s: %d records in %d files, chain ok\n", file, n, len(files))
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,325 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ezbastion/ezb_lib/logmanager"
+)
+
+// Record is one audited operation. Prev is the hash of the previous record and
+// Hash the sha256 of Prev and the record itself, so any change break the chain.
+type Record struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Sub    string    `json:"sub"`
+	Iss    string    `json:"iss"`
+	Jti    string    `json:"jti"`
+	IP     string    `json:"ip"`
+	Owner  string    `json:"owner,omitempty"`
+	Key    string    `json:"key,omitempty"`
+	Action string    `json:"action"`
+	Status int       `json:"status"`
+	Result string    `json:"result"`
+	Error  string    `json:"error,omitempty"`
+	Prev   string    `json:"prev"`
+	Hash   string    `json:"hash"`
+}
+
+// Sum compute the record hash, Hash itself is not part of it.
+func (r Record) Sum() string {
+	r.Hash = ""
+	b, _ := json.Marshal(r)
+	h := sha256.New()
+	h.Write([]byte(r.Prev))
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Sink receive every audit record, already chained and encoded as one json line.
+type Sink interface {
+	Name() string
+	Write(r Record, line []byte) error
+	Close() error
+}
+
+// chained is implemented by sinks able to give back the last record written,
+// so the chain continue after a restart.
+type chained interface {
+	Last() (seq uint64, hash string)
+}
+
+// queued is implemented by the sinks writing in background, a record they
+// accepted with Write may still be lost. Deliver queue the record too, and
+// tell on the channel if it was sent at the first try, within Timeout.
+type queued interface {
+	Deliver(r Record, line []byte) (<-chan error, error)
+	Timeout() time.Duration
+}
+
+// queuedRecord is a record waiting in a sink queue. ack, if set, get the
+// result of the first try.
+type queuedRecord struct {
+	line []byte
+	ack  chan error
+}
+
+func (q *queuedRecord) done(err error) {
+	if q.ack != nil {
+		q.ack <- err
+		q.ack = nil
+	}
+}
+
+func enqueue(queue chan queuedRecord, line []byte, ack chan error) error {
+	select {
+	case queue <- queuedRecord{line: line, ack: ack}:
+		return nil
+	default:
+		return errors.New("queue full")
+	}
+}
+
+// Logger chain the records and dispatch them to the sinks.
+type Logger struct {
+	mu         sync.Mutex
+	sinks      []Sink
+	failClosed bool
+	seq        uint64
+	last       string
+}
+
+// New build a logger over sinks. With failClosed, the caller must refuse the
+// operation when Log return an error.
+func New(sinks []Sink, failClosed bool) *Logger {
+	l := &Logger{sinks: sinks, failClosed: failClosed}
+	for _, s := range sinks {
+		if c, ok := s.(chained); ok {
+			if seq, hash := c.Last(); seq > l.seq {
+				l.seq, l.last = seq, hash
+			}
+		}
+	}
+	return l
+}
+
+// Open return a logger writing to a single chained file.
+func Open(path string) (*Logger, error) {
+	f, err := NewFileSink(path)
+	if err != nil {
+		return nil, err
+	}
+	return New([]Sink{f}, false), nil
+}
+
+// FailClosed tell if requests must fail when the record is not accepted.
+func (l *Logger) FailClosed() bool {
+	return l.failClosed
+}
+
+// Log chain the record and write it to every sink. The error is set if no sink
+// accepted the record, the chain is then not moved. With failClosed, the
+// queued sinks must send the record at once to accept it, the error is set
+// if none did and no other sink wrote it.
+func (l *Logger) Log(r Record) error {
+	acks, err := l.write(r)
+	if err != nil || len(acks) == 0 {
+		return err
+	}
+	results := make(chan error, len(acks))
+	for name, a := range acks {
+		go func(name string, a ack) {
+			select {
+			case err := <-a.c:
+				if err != nil {
+					err = fmt.Errorf("%s: %v", name, err)
+				}
+				results <- err
+			case <-time.After(a.timeout):
+				results <- fmt.Errorf("%s: not sent in %s", name, a.timeout)
+			}
+		}(name, a)
+	}
+	var errs []string
+	for range acks {
+		err := <-results
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return fmt.Errorf("no audit sink accepted the record: %s", strings.Join(errs, ", "))
+}
+
+type ack struct {
+	c       <-chan error
+	timeout time.Duration
+}
+
+// write chain the record and give it to the sinks. With failClosed, when
+// no other sink wrote it, acks are the queued sinks to wait for. They are
+// waited without the lock, the next records are queued meanwhile.
+func (l *Logger) write(r Record) (map[string]ack, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	r.Seq = l.seq + 1
+	r.Prev = l.last
+	r.Hash = r.Sum()
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	acks := map[string]ack{}
+	written := 0
+	for _, s := range l.sinks {
+		if q, ok := s.(queued); ok && l.failClosed {
+			c, err := q.Deliver(r, b)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", s.Name(), err))
+			} else {
+				acks[s.Name()] = ack{c: c, timeout: q.Timeout()}
+			}
+			continue
+		}
+		if err := s.Write(r, b); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", s.Name(), err))
+		} else {
+			written++
+		}
+	}
+	if len(errs) == len(l.sinks) {
+		return nil, fmt.Errorf("no audit sink accepted the record: %s", strings.Join(errs, ", "))
+	}
+	if len(errs) > 0 {
+		logmanager.Error(fmt.Sprintf("audit record seq %d lost by %s", r.Seq, strings.Join(errs, ", ")))
+	}
+	// a queued record is kept and sent again, the chain move on
+	l.seq, l.last = r.Seq, r.Hash
+	if written > 0 {
+		return nil, nil
+	}
+	return acks, nil
+}
+
+// Close every sink.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var err error
+	for _, s := range l.sinks {
+		if e := s.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func lastRecord(path string) (last Record, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return last, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(sc.Bytes(), &last); err != nil {
+			return last, err
+		}
+	}
+	return last, sc.Err()
+}
+
+// Verify walk the chain of one file, or of rotated files given from the oldest
+// to the newest, and return the number of valid records or the first modified,
+// missing or out of order record. The last record must match the .head file of
+// the last path if it exists. With partial, the first record may be chained to
+// an older one, rotated out.
+func Verify(partial bool, paths ...string) (int, error) {
+	var prev Record
+	n := 0
+	for _, path := range paths {
+		if err := verifyFile(path, partial, &prev, &n); err != nil {
+			return n, err
+		}
+	}
+	if len(paths) == 0 {
+		return 0, fmt.Errorf("no audit file")
+	}
+	head, err := ioutil.ReadFile(paths[len(paths)-1] + ".head")
+	if err == nil {
+		var seq uint64
+		var hash string
+		if _, err := fmt.Sscan(strings.TrimSpace(string(head)), &seq, &hash); err != nil {
+			return n, fmt.Errorf("unreadable head file: %v", err)
+		}
+		if seq != prev.Seq || hash != prev.Hash {
+			return n, fmt.Errorf("log truncated: last record is seq %d, head is seq %d", prev.Seq, seq)
+		}
+	}
+	return n, nil
+}
+
+func verifyFile(path string, partial bool, prev *Record, n *int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return fmt.Errorf("%s line %d: unreadable record: %v", path, line, err)
+		}
+		if *n == 0 && r.Prev != "" && !partial {
+			return fmt.Errorf("%s line %d: first record seq %d is chained to a missing record", path, line, r.Seq)
+		}
+		if *n > 0 && (r.Prev != prev.Hash || r.Seq != prev.Seq+1) {
+			return fmt.Errorf("%s line %d: record seq %d does not follow seq %d", path, line, r.Seq, prev.Seq)
+		}
+		if r.Sum() != r.Hash {
+			return fmt.Errorf("%s line %d: record seq %d has been modified", path, line, r.Seq)
+		}
+		*prev = r
+		*n++
+	}
+	return sc.Err()
+}
@@ -0,0 +1,204 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeLog(t *testing.T, n int) string {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "audit.log")
+	for i := 0; i < 2; i++ {
+		// reopen once, the chain must continue
+		l, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < n; j++ {
+			if err := l.Log(Record{Sub: "user0", Key: "key0", Action: "read", Status: 200, Result: "success"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		l.Close()
+	}
+	return path
+}
+
+func TestVerify(t *testing.T) {
+	path := writeLog(t, 3)
+	defer os.RemoveAll(filepath.Dir(path))
+	n, err := Verify(false, path)
+	if err != nil || n != 6 {
+		t.Errorf("TestVerify was incorrect, got: <%d, %v>, want: <6, nil>.", n, err)
+	}
+}
+
+func TestVerifyModified(t *testing.T) {
+	path := writeLog(t, 3)
+	defer os.RemoveAll(filepath.Dir(path))
+	raw, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path, []byte(strings.Replace(string(raw), `"sub":"user0"`, `"sub":"user1"`, 1)), 0600)
+	if _, err := Verify(false, path); err == nil {
+		t.Errorf("TestVerifyModified was incorrect, modified record not detected.")
+	}
+}
+
+func TestVerifyTruncated(t *testing.T) {
+	path := writeLog(t, 3)
+	defer os.RemoveAll(filepath.Dir(path))
+	raw, _ := ioutil.ReadFile(path)
+	lines := strings.SplitAfter(string(raw), "\n")
+	ioutil.WriteFile(path, []byte(strings.Join(lines[:4], "")), 0600)
+	if _, err := Verify(false, path); err == nil {
+		t.Errorf("TestVerifyTruncated was incorrect, truncated tail not detected.")
+	}
+	ioutil.WriteFile(path, []byte(strings.Join(lines[1:], "")), 0600)
+	if _, err := Verify(false, path); err == nil {
+		t.Errorf("TestVerifyTruncated was incorrect, truncated head not detected.")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	s, err := NewRotateSink(path, 1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New([]Sink{s}, false)
+	for i := 0; i < 20; i++ {
+		l.Log(Record{Sub: "user0", Key: "key0", Action: "read", Status: 200, Result: "success"})
+	}
+	l.Close()
+	files := RotatedFiles(path, 2)
+	if len(files) != 3 {
+		t.Errorf("TestRotate was incorrect, got: <%d files>, want: <3 files>.", len(files))
+	}
+	if _, err := Verify(false, files...); err == nil {
+		t.Errorf("TestRotate was incorrect, removed oldest file not detected.")
+	}
+	n, err := Verify(true, files...)
+	if err != nil || n == 0 || n >= 20 {
+		t.Errorf("TestRotate was incorrect, got: <%d, %v>, want: <less than 20, nil>.", n, err)
+	}
+}
+
+func TestSyslogFormat(t *testing.T) {
+	s, err := NewSyslogSink("udp", "127.0.0.1:514", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Record{Result: "failure"}
+	got := string(s.format(r, []byte(`{}`)))
+	if !strings.HasPrefix(got, "<84>1 ") || !strings.HasSuffix(got, " ezb_vault "+strconv.Itoa(os.Getpid())+" audit - {}") {
+		t.Errorf("TestSyslogFormat was incorrect, got: <%s>.", got)
+	}
+}
+
+func TestFailClosed(t *testing.T) {
+	// nothing listen on the syslog address, the record stay queued
+	down, err := NewSyslogSink("tcp", "127.0.0.1:1", 10, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer down.Close()
+	start := time.Now()
+	if err := New([]Sink{down}, true).Log(Record{Action: "read"}); err == nil {
+		t.Errorf("TestFailClosed not sent was incorrect, got: <nil>, want: <error>.")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("TestFailClosed was incorrect, Log took <%s> with the syslog server down.", d)
+	}
+	if err := New([]Sink{down}, false).Log(Record{Action: "read"}); err != nil {
+		t.Errorf("TestFailClosed fail open was incorrect, got: <%v>, want: <nil>.", err)
+	}
+	// a record sent to the collector is accepted, without file
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	up, err := NewHTTPSink(srv.URL, nil, 10, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+	if err := New([]Sink{down, up}, true).Log(Record{Action: "read"}); err != nil {
+		t.Errorf("TestFailClosed sent was incorrect, got: <%v>, want: <nil>.", err)
+	}
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	f, err := NewFileSink(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	if err := New([]Sink{f, down}, true).Log(Record{Action: "read"}); err != nil {
+		t.Errorf("TestFailClosed with file was incorrect, got: <%v>, want: <nil>.", err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("TestFailClosed with file was incorrect, Log took <%s>, want: <no wait>.", d)
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('}')
+		lines <- line
+	}()
+	s, err := NewSyslogSink("tcp", l.Addr().String(), 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Write(Record{Result: "success"}, []byte(`{}`)); err != nil {
+		t.Fatalf("TestSyslogSink write failed: %v", err)
+	}
+	select {
+	case line := <-lines:
+		if !strings.Contains(line, "<86>1 ") || !strings.HasSuffix(line, "audit - {}") {
+			t.Errorf("TestSyslogSink was incorrect, got: <%s>.", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("TestSyslogSink was incorrect, record not received.")
+	}
+}
@@ -0,0 +1,75 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/configuration"
+)
+
+// FromConf build the logger from the audit section of config.json, without
+// sink the records go to the AuditPath file as before.
+func FromConf(conf configuration.Configuration, exPath string) (*Logger, error) {
+	if len(conf.Audit.Sinks) == 0 {
+		f, err := NewFileSink(conf.AuditFile(exPath))
+		if err != nil {
+			return nil, err
+		}
+		return New([]Sink{f}, conf.Audit.FailClosed), nil
+	}
+	var sinks []Sink
+	for i, sc := range conf.Audit.Sinks {
+		s, err := newSink(sc, conf, exPath)
+		if err != nil {
+			for _, o := range sinks {
+				o.Close()
+			}
+			return nil, fmt.Errorf("audit sink %d: %v", i+1, err)
+		}
+		sinks = append(sinks, s)
+	}
+	return New(sinks, conf.Audit.FailClosed), nil
+}
+
+func newSink(sc configuration.AuditSink, conf configuration.Configuration, exPath string) (Sink, error) {
+	timeout := time.Duration(sc.Timeout) * time.Second
+	switch sc.Type {
+	case "file":
+		return NewFileSink(SinkPath(sc.Path, conf, exPath))
+	case "rotate":
+		return NewRotateSink(SinkPath(sc.Path, conf, exPath), int64(sc.MaxSize)*1024*1024, sc.MaxFiles)
+	case "syslog":
+		return NewSyslogSink(sc.Network, sc.Address, sc.Buffer, timeout)
+	case "http":
+		return NewHTTPSink(sc.URL, sc.Headers, sc.Buffer, timeout)
+	}
+	return nil, fmt.Errorf("unknown type '%s', must be file, rotate, syslog or http", sc.Type)
+}
+
+// SinkPath default to AuditPath, a relative path is from the vault folder.
+func SinkPath(p string, conf configuration.Configuration, exPath string) string {
+	if p == "" {
+		return conf.AuditFile(exPath)
+	}
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return path.Join(exPath, p)
+}
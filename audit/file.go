@@ -0,0 +1,144 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// FileSink append the records to a file, the last sequence and hash are also
+// written to a .head file, to detect a truncated log.
+type FileSink struct {
+	path string
+	f    *os.File
+	size int64
+	seq  uint64
+	last string
+}
+
+// NewFileSink open the file, the chain continue from its last record.
+func NewFileSink(path string) (*FileSink, error) {
+	s := &FileSink{path: path}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	if fi, err := os.Stat(s.path); err == nil {
+		last, err := lastRecord(s.path)
+		if err != nil {
+			return err
+		}
+		s.seq, s.last, s.size = last.Seq, last.Hash, fi.Size()
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	return nil
+}
+
+func (s *FileSink) Name() string {
+	return "file:" + s.path
+}
+
+func (s *FileSink) Write(r Record, line []byte) error {
+	n, err := s.f.Write(append(line, '\n'))
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	s.seq, s.last = r.Seq, r.Hash
+	return ioutil.WriteFile(s.path+".head", []byte(fmt.Sprintf("%d %s\n", r.Seq, r.Hash)), 0600)
+}
+
+func (s *FileSink) Last() (uint64, string) {
+	return s.seq, s.last
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// RotateSink is a FileSink renamed to .1, .2 ... when it reach maxSize bytes,
+// keeping maxFiles old files. The chain continue across the files.
+type RotateSink struct {
+	FileSink
+	maxSize  int64
+	maxFiles int
+}
+
+func NewRotateSink(path string, maxSize int64, maxFiles int) (*RotateSink, error) {
+	s := &RotateSink{FileSink: FileSink{path: path}, maxSize: maxSize, maxFiles: maxFiles}
+	if s.maxFiles < 1 {
+		s.maxFiles = 1
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RotateSink) Name() string {
+	return "rotate:" + s.path
+}
+
+func (s *RotateSink) Write(r Record, line []byte) error {
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line))+1 > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	return s.FileSink.Write(r, line)
+}
+
+func (s *RotateSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxFiles))
+	for i := s.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	seq, last := s.seq, s.last
+	s.size = 0
+	if err := s.open(); err != nil {
+		return err
+	}
+	s.seq, s.last = seq, last
+	return nil
+}
+
+// RotatedFiles return path.N ... path.1 and path, skipping the missing ones,
+// oldest first as expected by Verify.
+func RotatedFiles(path string, maxFiles int) []string {
+	var files []string
+	for i := maxFiles; i > 0; i-- {
+		p := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(p); err == nil {
+			files = append(files, p)
+		}
+	}
+	return append(files, path)
+}
@@ -0,0 +1,139 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ezbastion/ezb_lib/logmanager"
+)
+
+// HTTPSink post each record to a webhook. Records are queued, so a slow or
+// down collector does not slow the requests, and retried until the collector
+// answer 2xx. A record is refused only when the queue is full.
+type HTTPSink struct {
+	url     string
+	headers map[string]string
+	timeout time.Duration
+	client  *http.Client
+	queue   chan queuedRecord
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func NewHTTPSink(url string, headers map[string]string, buffer int, timeout time.Duration) (*HTTPSink, error) {
+	if url == "" {
+		return nil, errors.New("http sink url is mandatory")
+	}
+	if buffer <= 0 {
+		buffer = 1000
+	}
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	s := &HTTPSink{
+		url:     url,
+		headers: headers,
+		timeout: timeout,
+		client:  &http.Client{Timeout: timeout},
+		queue:   make(chan queuedRecord, buffer),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *HTTPSink) Name() string {
+	return "http:" + s.url
+}
+
+func (s *HTTPSink) Write(r Record, line []byte) error {
+	return enqueue(s.queue, append([]byte(nil), line...), nil)
+}
+
+// Deliver queue the record, the channel get the result of its first post.
+func (s *HTTPSink) Deliver(r Record, line []byte) (<-chan error, error) {
+	ack := make(chan error, 1)
+	return ack, enqueue(s.queue, append([]byte(nil), line...), ack)
+}
+
+// Timeout is the longest a post can take.
+func (s *HTTPSink) Timeout() time.Duration {
+	return s.timeout
+}
+
+func (s *HTTPSink) run() {
+	defer close(s.stopped)
+	for {
+		select {
+		case <-s.done:
+			return
+		case q := <-s.queue:
+			backoff := time.Second
+			for {
+				err := s.post(q.line)
+				q.done(err)
+				if err == nil {
+					break
+				}
+				logmanager.Warning(fmt.Sprintf("audit %s: %v, retry in %s", s.Name(), err, backoff))
+				select {
+				case <-s.done:
+					return
+				case <-time.After(backoff):
+				}
+				if backoff < time.Minute {
+					backoff *= 2
+				}
+			}
+		}
+	}
+}
+
+func (s *HTTPSink) post(b []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Close stop the forwarder, records still queued are lost and logged.
+func (s *HTTPSink) Close() error {
+	close(s.done)
+	<-s.stopped
+	if n := len(s.queue); n > 0 {
+		return fmt.Errorf("%d audit records not forwarded to %s", n, s.url)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ezbastion/ezb_lib/logmanager"
+)
+
+const (
+	// authpriv facility, info and warning severity.
+	facilityAuthpriv = 10
+	severityWarning  = 4
+	severityInfo     = 6
+	// RFC 5424 allow at most 6 digits of second fraction.
+	timestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// SyslogSink send the records as RFC 5424 messages, over udp or tcp. Tcp
+// messages are framed with their length (RFC 6587 octet counting). Like
+// HTTPSink the records are queued, so a slow or down server does not slow
+// the requests, and sent again until the server take them.
+type SyslogSink struct {
+	network  string
+	address  string
+	timeout  time.Duration
+	hostname string
+	conn     net.Conn
+	queue    chan queuedRecord
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+func NewSyslogSink(network, address string, buffer int, timeout time.Duration) (*SyslogSink, error) {
+	if network != "tcp" && network != "udp" {
+		return nil, fmt.Errorf("syslog network must be tcp or udp, got '%s'", network)
+	}
+	if buffer <= 0 {
+		buffer = 1000
+	}
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	// the connection is made on the first record, a syslog server down at
+	// start must not prevent the vault to start.
+	s := &SyslogSink{
+		network:  network,
+		address:  address,
+		timeout:  timeout,
+		hostname: hostname,
+		queue:    make(chan queuedRecord, buffer),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *SyslogSink) Name() string {
+	return "syslog:" + s.network + "://" + s.address
+}
+
+// format build the RFC 5424 message: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+func (s *SyslogSink) format(r Record, line []byte) []byte {
+	severity := severityInfo
+	if r.Result != "success" {
+		severity = severityWarning
+	}
+	return []byte(fmt.Sprintf("<%d>1 %s %s ezb_vault %d audit - %s",
+		facilityAuthpriv*8+severity, r.Time.UTC().Format(timestampFormat), s.hostname, os.Getpid(), line))
+}
+
+func (s *SyslogSink) message(r Record, line []byte) []byte {
+	msg := s.format(r, line)
+	if s.network == "tcp" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+	return msg
+}
+
+func (s *SyslogSink) Write(r Record, line []byte) error {
+	return enqueue(s.queue, s.message(r, line), nil)
+}
+
+// Deliver queue the record, the channel get the result of its first sending.
+func (s *SyslogSink) Deliver(r Record, line []byte) (<-chan error, error) {
+	ack := make(chan error, 1)
+	return ack, enqueue(s.queue, s.message(r, line), ack)
+}
+
+// Timeout is the longest a sending can take, connection and write.
+func (s *SyslogSink) Timeout() time.Duration {
+	return 2 * s.timeout
+}
+
+func (s *SyslogSink) run() {
+	defer close(s.stopped)
+	for {
+		select {
+		case <-s.done:
+			return
+		case q := <-s.queue:
+			backoff := time.Second
+			for {
+				err := s.send(q.line)
+				q.done(err)
+				if err == nil {
+					break
+				}
+				logmanager.Warning(fmt.Sprintf("audit %s: %v, retry in %s", s.Name(), err, backoff))
+				select {
+				case <-s.done:
+					return
+				case <-time.After(backoff):
+				}
+				if backoff < time.Minute {
+					backoff *= 2
+				}
+			}
+		}
+	}
+}
+
+// send write msg, with a new connection if the server closed the previous one.
+func (s *SyslogSink) send(msg []byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, s.timeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	if _, err := s.conn.Write(msg); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close stop the sender, records still queued are lost.
+func (s *SyslogSink) Close() error {
+	close(s.done)
+	<-s.stopped
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	if n := len(s.queue); n > 0 {
+		return fmt.Errorf("%d audit records not sent to %s", n, s.Name())
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ezbastion/ezb_lib/logmanager"
+	"github.com/ezbastion/ezb_vault/configuration"
+	"golang.org/x/crypto/ocsp"
+)
+
+// certStore hold the server certificate, served through tls.Config.GetCertificate
+// so it can be replaced without restarting the listener.
+type certStore struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	ocsp string
+}
+
+// Load read the certificate and key files of conf, the current one is kept if
+// they are unreadable, expired or do not match conf.SAN.
+func (s *certStore) Load(conf configuration.Configuration, exPath string) error {
+	cert, err := tls.LoadX509KeyPair(path.Join(exPath, conf.PublicCert), path.Join(exPath, conf.PrivateKey))
+	if err != nil {
+		return err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+	if err := checkCert(cert.Leaf, conf.SAN, time.Now()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cert = &cert
+	s.ocsp = ""
+	if conf.TLS.OCSPStaple != "" {
+		s.ocsp = path.Join(exPath, conf.TLS.OCSPStaple)
+		s.staple()
+	}
+	return nil
+}
+
+// checkCert refuse a certificate out of its validity period or not valid for
+// every name of san.
+func checkCert(leaf *x509.Certificate, san []string, now time.Time) error {
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("certificate %s is not valid before %s", leaf.Subject.CommonName, leaf.NotBefore)
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate %s expired on %s", leaf.Subject.CommonName, leaf.NotAfter)
+	}
+	for _, name := range san {
+		if err := leaf.VerifyHostname(name); err != nil {
+			return fmt.Errorf("certificate %s does not match san: %s", leaf.Subject.CommonName, err.Error())
+		}
+	}
+	return nil
+}
+
+// RefreshStaple read the OCSP response file again.
+func (s *certStore) RefreshStaple() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ocsp != "" && s.cert != nil {
+		s.staple()
+	}
+}
+
+// staple attach the OCSP response to the certificate, a response unreadable,
+// for another certificate or out of date is logged and not stapled.
+func (s *certStore) staple() {
+	cert := *s.cert
+	cert.OCSPStaple = nil
+	s.cert = &cert
+	der, err := ioutil.ReadFile(s.ocsp)
+	if err != nil {
+		logmanager.Warning(fmt.Sprintf("ocsp staple not loaded: %s", err.Error()))
+		return
+	}
+	var issuer *x509.Certificate
+	if len(cert.Certificate) > 1 {
+		issuer, _ = x509.ParseCertificate(cert.Certificate[1])
+	}
+	resp, err := ocsp.ParseResponseForCert(der, cert.Leaf, issuer)
+	if err != nil {
+		logmanager.Warning(fmt.Sprintf("ocsp staple not loaded: %s", err.Error()))
+		return
+	}
+	if !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate) {
+		logmanager.Warning(fmt.Sprintf("ocsp staple not loaded: out of date since %s", resp.NextUpdate))
+		return
+	}
+	cert.OCSPStaple = der
+}
+
+// Leaf return the parsed certificate served.
+func (s *certStore) Leaf() *x509.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert == nil {
+		return nil
+	}
+	return s.cert.Leaf
+}
+
+func (s *certStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, nil
+}
@@ -0,0 +1,61 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCheckCert(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ezb_vault"},
+		DNSNames:     []string{"vault.local", "vault"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	tests := []struct {
+		name string
+		san  []string
+		now  time.Time
+		ok   bool
+	}{
+		{"valid", []string{"vault.local", "vault"}, now, true},
+		{"no san", nil, now, true},
+		{"not yet valid", []string{"vault.local"}, now.Add(-2 * time.Hour), false},
+		{"expired", []string{"vault.local"}, now.Add(48 * time.Hour), false},
+		{"san mismatch", []string{"vault.local", "other.local"}, now, false},
+	}
+	for _, tt := range tests {
+		if err := checkCert(leaf, tt.san, tt.now); (err == nil) != tt.ok {
+			t.Errorf("TestCheckCert %s was incorrect, got: <%v>, want: <ok %t>.", tt.name, err, tt.ok)
+		}
+	}
+}
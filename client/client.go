@@ -0,0 +1,392 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package client call an ezb_vault from Go. It send the token and the
+// EZB-VAULT-KEY headers, map the #V codes to errors and retry the requests
+// refused by a busy or restarting vault.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header names of the vault api.
+const (
+	HeaderKey       = "EZB-VAULT-KEY"
+	HeaderShareKey  = "EZB-VAULT-SHARE-KEY"
+	HeaderBundleKey = "EZB-VAULT-BUNDLE-KEY"
+)
+
+// Import modes, for a key already in the vault.
+const (
+	ImportSkip      = "skip"
+	ImportOverwrite = "overwrite"
+	ImportFail      = "fail"
+)
+
+// Secret is a key/value pair. Owner and Shared are set for the secrets shared
+// with the token subject.
+type Secret struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Owner  string `json:"owner,omitempty"`
+	Shared bool   `json:"shared,omitempty"`
+}
+
+// Version is a value of a secret, Current for the one Get return.
+type Version struct {
+	Version int       `json:"version"`
+	Value   string    `json:"value"`
+	Written time.Time `json:"written"`
+	Current bool      `json:"current,omitempty"`
+}
+
+// Config set a Client. Addr and Token are required. Key is the passphrase of
+// the owned secrets, ShareKey the one of the shared secrets (Key by default).
+// CACert is a PEM file of the vault CA, the system ones are used without it.
+// Addr may be a unix:/path socket, like the one of an ezb_vault proxy.
+// A request failing on a network error or a busy vault is sent Retries times
+// more (3 by default, negative for none), waiting Backoff (200ms by default)
+// doubled each time. A request with a one-time token is never sent again.
+type Config struct {
+	Addr       string
+	Token      TokenSource
+	Key        string
+	ShareKey   string
+	CACert     string
+	HTTPClient *http.Client
+	Timeout    time.Duration
+	Retries    int
+	Backoff    time.Duration
+}
+
+// Client call the vault api, it is safe for concurrent use.
+type Client struct {
+	addr      string
+	token     TokenSource
+	key       string
+	shareKey  string
+	bundleKey string
+	owner     string
+	http      *http.Client
+	retries   int
+	backoff   time.Duration
+}
+
+// New return a Client for conf.
+func New(conf Config) (*Client, error) {
+	addr, err := baseURL(conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Token == nil {
+		return nil, errors.New("vault token source is required")
+	}
+	c := &Client{
+		addr:     addr,
+		token:    conf.Token,
+		key:      conf.Key,
+		shareKey: conf.ShareKey,
+		http:     conf.HTTPClient,
+		retries:  conf.Retries,
+		backoff:  conf.Backoff,
+	}
+	if c.retries == 0 {
+		c.retries = 3
+	}
+	if c.backoff <= 0 {
+		c.backoff = 200 * time.Millisecond
+	}
+	if c.http == nil {
+		if c.http, err = NewHTTPClient(conf.Addr, conf.CACert, conf.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// baseURL check addr, a unix:/path socket is called as http://unix.
+func baseURL(addr string) (string, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "http://unix", nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("vault address must be https://host:port or unix:/path, got '%s'", addr)
+	}
+	return strings.TrimSuffix(addr, "/"), nil
+}
+
+// NewHTTPClient return the http client of a vault at addr, trusting the PEM
+// caCert or the system CAs. A unix:/path addr dial the socket.
+func NewHTTPClient(addr, caCert string, timeout time.Duration) (*http.Client, error) {
+	if _, err := baseURL(addr); err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caCert != "" {
+		raw, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("no certificate found in %s", caCert)
+		}
+	}
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	tr := &http.Transport{TLSClientConfig: tlsConf, Proxy: http.ProxyFromEnvironment, MaxIdleConnsPerHost: 16}
+	if strings.HasPrefix(addr, "unix:") {
+		socket := strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//")
+		var d net.Dialer
+		tr.Proxy = nil
+		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+	return &http.Client{Timeout: timeout, Transport: tr}, nil
+}
+
+// WithOwner return a copy of c reading the secrets of owner, shared with the
+// token subject.
+func (c *Client) WithOwner(owner string) *Client {
+	o := *c
+	o.owner = owner
+	return &o
+}
+
+// Get return a secret. A secret missing, expired or not opened by the key is
+// ErrNotFound.
+func (c *Client) Get(ctx context.Context, name string) (Secret, error) {
+	var s Secret
+	err := c.do(ctx, http.MethodGet, name, nil, &s)
+	return s, err
+}
+
+// List return the secrets opened by the key, owned and shared ones.
+func (c *Client) List(ctx context.Context) ([]Secret, error) {
+	var out []Secret
+	err := c.do(ctx, http.MethodGet, "", nil, &out)
+	if errors.Is(err, ErrNotFound) {
+		return []Secret{}, nil
+	}
+	return out, err
+}
+
+// Versions return the values of a secret, the current one first then the
+// previous ones, newest first. The vault keep the last 10 previous values.
+func (c *Client) Versions(ctx context.Context, name string) ([]Version, error) {
+	var out []Version
+	err := c.doWith(ctx, http.MethodGet, "/"+url.PathEscape(name)+"/versions", nil, nil, &out)
+	return out, err
+}
+
+// Put create a secret.
+func (c *Client) Put(ctx context.Context, name, value string) error {
+	return c.do(ctx, http.MethodPost, "", Secret{Key: name, Value: value}, nil)
+}
+
+// Update replace the value of a secret, ErrNotFound if it does not exist.
+func (c *Client) Update(ctx context.Context, name, value string) error {
+	return c.do(ctx, http.MethodPut, name, Secret{Value: value}, nil)
+}
+
+// Set update a secret, or create it.
+func (c *Client) Set(ctx context.Context, name, value string) error {
+	err := c.Update(ctx, name, value)
+	if errors.Is(err, ErrNotFound) {
+		return c.Put(ctx, name, value)
+	}
+	return err
+}
+
+// Delete remove a secret and its shares.
+func (c *Client) Delete(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, name, nil, nil)
+}
+
+// ImportReport tell what an import did, or would do for a dry run. Errors
+// hold the refused keys and why.
+type ImportReport struct {
+	Mode        string            `json:"mode"`
+	DryRun      bool              `json:"dryrun"`
+	Created     []string          `json:"created"`
+	Overwritten []string          `json:"overwritten"`
+	Skipped     []string          `json:"skipped"`
+	Conflicts   []string          `json:"conflicts"`
+	Errors      map[string]string `json:"errors,omitempty"`
+}
+
+// Export return a bundle of the secrets opened by the key, sealed with
+// passphrase. The bundle is a JSON document, to keep as is.
+func (c *Client) Export(ctx context.Context, passphrase string) ([]byte, error) {
+	o := *c
+	o.bundleKey = passphrase
+	var out json.RawMessage
+	err := o.doWith(ctx, http.MethodGet, "/sys/export", nil, nil, &out)
+	return out, err
+}
+
+// Import write the secrets of bundle, opened with passphrase. mode tell what
+// to do with a key already in the vault, ImportSkip by default. A dry run
+// only return the report. When the vault refuse the import, nothing is
+// written and the report come with the error.
+func (c *Client) Import(ctx context.Context, bundle []byte, passphrase, mode string, dryRun bool) (ImportReport, error) {
+	o := *c
+	o.bundleKey = passphrase
+	if mode == "" {
+		mode = ImportSkip
+	}
+	var report ImportReport
+	q := url.Values{"mode": {mode}, "dryrun": {strconv.FormatBool(dryRun)}}
+	err := o.doWith(ctx, http.MethodPost, "/sys/import", q, json.RawMessage(bundle), &report)
+	var e *Error
+	if errors.As(err, &e) && (e.Status == http.StatusConflict || e.Status == http.StatusUnprocessableEntity) {
+		json.Unmarshal(e.body, &report)
+	}
+	return report, err
+}
+
+// do send the request of the name secret.
+func (c *Client) do(ctx context.Context, method, name string, in, out interface{}) error {
+	return c.doWith(ctx, method, "/"+url.PathEscape(name), nil, in, out)
+}
+
+// doWith send the request, again on a retryable failure, and read the answer
+// in out.
+func (c *Client) doWith(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	if c.owner != "" {
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("owner", c.owner)
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	wait := c.backoff
+	for attempt := 0; ; attempt++ {
+		token, err := c.token.Token()
+		if err != nil {
+			return fmt.Errorf("ezb_vault token: %v", err)
+		}
+		err = c.send(ctx, token, method, path, body, out)
+		if err == nil || attempt >= c.retries || !retryable(err, method, oneTime(token)) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// retryable tell if the request can be sent again. A request with a one-time
+// token may have used it, sent again it would be a replay.
+func retryable(err error, method string, once bool) bool {
+	if once {
+		return false
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.retryable()
+	}
+	// a network error, the request may have been handled
+	return method != http.MethodPost
+}
+
+// oneTime tell if token carry the once claim. The token is not verified.
+func oneTime(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return false
+	}
+	var claims struct {
+		Once bool `json:"once"`
+	}
+	return json.Unmarshal(raw, &claims) == nil && claims.Once
+}
+
+func (c *Client) send(ctx context.Context, token, method, path string, body []byte, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.addr+path, r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.key != "" {
+		req.Header.Set(HeaderKey, c.key)
+	}
+	if c.shareKey != "" {
+		req.Header.Set(HeaderShareKey, c.shareKey)
+	}
+	if c.bundleKey != "" {
+		req.Header.Set(HeaderBundleKey, c.bundleKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return err
+	}
+	// the vault answer 204 for a secret it cannot return
+	if resp.StatusCode >= 300 || (resp.StatusCode == http.StatusNoContent && method == http.MethodGet) {
+		return newError(method, path, resp.StatusCode, raw)
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent && len(raw) > 0 {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/ezbastion/ezb_vault/Middleware"
+	"github.com/ezbastion/ezb_vault/audit"
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/ezbastion/ezb_vault/ctrl"
+	"github.com/ezbastion/ezb_vault/routes"
+	"github.com/gin-gonic/gin"
+)
+
+// vault start an in-process vault trusting a new ezb_sta key, and return a
+// client for sub.
+func vault(t *testing.T, conf configuration.Configuration) func(sub, key string) *Client {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "db"), 0700)
+	os.MkdirAll(filepath.Join(dir, "cert"), 0700)
+	sta, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pub, _ := x509.MarshalPKIXPublicKey(&sta.PublicKey)
+	ioutil.WriteFile(filepath.Join(dir, "cert", "ezb_sta.crt"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0600)
+
+	conf.DB = "db/test.db"
+	db, err := configuration.InitDB(conf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := configuration.NewLive(conf, dir)
+	al, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl, _ := Middleware.NewRevocationList(db)
+	ps, _ := Middleware.NewPolicyStore(db)
+	gin.SetMode(gin.ReleaseMode)
+	r := routes.New(routes.Vault{
+		Live:       live,
+		DB:         db,
+		Audit:      al,
+		Guard:      Middleware.NewGuard(live, al),
+		Revocation: rl,
+		Policies:   ps,
+		Keys:       Middleware.NewIssuerKeys(filepath.Join(dir, "cert")),
+		Server:     &ctrl.Server{},
+	})
+	srv := httptest.NewTLSServer(r)
+	t.Cleanup(func() {
+		srv.Close()
+		al.Close()
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	return func(sub, key string) *Client {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"iss": "ezb_sta",
+			"sub": sub,
+			"jti": fmt.Sprint(time.Now().UnixNano()),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}).SignedString(sta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := New(Config{Addr: srv.URL, Token: StaticToken(token), Key: key, HTTPClient: srv.Client(), Retries: -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	alice := vault(t, configuration.Configuration{})("alice", "alicekey")
+	if err := alice.Put(ctx, "db-pass", "s3cret"); err != nil {
+		t.Fatalf("TestClient Put failed: %v", err)
+	}
+	if err := alice.Set(ctx, "prod/sql", "first"); err != nil {
+		t.Fatalf("TestClient Set failed: %v", err)
+	}
+	if err := alice.Update(ctx, "db-pass", "n3w"); err != nil {
+		t.Fatalf("TestClient Update failed: %v", err)
+	}
+	s, err := alice.Get(ctx, "db-pass")
+	if err != nil || s.Value != "n3w" {
+		t.Errorf("TestClient Get was incorrect, got: <%v %v>, want: <%s>.", s.Value, err, "n3w")
+	}
+	list, err := alice.List(ctx)
+	if err != nil || len(list) != 2 {
+		t.Errorf("TestClient List was incorrect, got: <%v %v>, want: <%d secrets>.", list, err, 2)
+	}
+	if err := alice.Update(ctx, "missing", "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("TestClient Update missing was incorrect, got: <%v>, want: <%v>.", err, ErrNotFound)
+	}
+	if err := alice.Delete(ctx, "db-pass"); err != nil {
+		t.Fatalf("TestClient Delete failed: %v", err)
+	}
+	if _, err := alice.Get(ctx, "db-pass"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("TestClient Get deleted was incorrect, got: <%v>, want: <%v>.", err, ErrNotFound)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+	login := vault(t, configuration.Configuration{})
+	alice := login("alice", "alicekey")
+	alice.Put(ctx, "db-pass", "v1")
+	for i := 2; i <= 13; i++ {
+		if err := alice.Update(ctx, "db-pass", fmt.Sprintf("v%d", i)); err != nil {
+			t.Fatalf("TestVersions Update failed: %v", err)
+		}
+	}
+	versions, err := alice.Versions(ctx, "db-pass")
+	if err != nil || len(versions) != 11 {
+		t.Fatalf("TestVersions was incorrect, got: <%d %v>, want: <%d>.", len(versions), err, 11)
+	}
+	// the current value first, then the last 10 previous ones
+	for i, v := range versions {
+		want := 13 - i
+		if v.Version != want || v.Value != fmt.Sprintf("v%d", want) || v.Current != (i == 0) {
+			t.Errorf("TestVersions #%d was incorrect, got: <%+v>, want: <version %d>.", i, v, want)
+		}
+	}
+	if _, err := login("alice", "wrongkey").Versions(ctx, "db-pass"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("TestVersions wrong key was incorrect, got: <%v>, want: <%v>.", err, ErrNotFound)
+	}
+	alice.Delete(ctx, "db-pass")
+	alice.Put(ctx, "db-pass", "new")
+	if versions, err := alice.Versions(ctx, "db-pass"); err != nil || len(versions) != 1 || versions[0].Version != 1 {
+		t.Errorf("TestVersions after delete was incorrect, got: <%+v %v>, want: <1 version>.", versions, err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	ctx := context.Background()
+	login := vault(t, configuration.Configuration{MaxValue: 10})
+	alice := login("alice", "alicekey")
+	if err := alice.Put(ctx, "k", "v"); err != nil {
+		t.Fatalf("TestClientErrors Put failed: %v", err)
+	}
+	err := login("alice", "wrongkey").Update(ctx, "k", "w")
+	var e *Error
+	if !errors.Is(err, ErrWrongKey) || !errors.As(err, &e) || e.Code != "#V0017" || e.Status != http.StatusForbidden {
+		t.Errorf("TestClientErrors wrong key was incorrect, got: <%v>, want: <%v>.", err, ErrWrongKey)
+	}
+	if err := alice.Put(ctx, "big", strings.Repeat("x", 11)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("TestClientErrors value was incorrect, got: <%v>, want: <%v>.", err, ErrTooLarge)
+	}
+	bob := login("bob", "bobkey").WithOwner("alice")
+	if _, err := bob.Get(ctx, "k"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("TestClientErrors owner was incorrect, got: <%v>, want: <%v>.", err, ErrForbidden)
+	}
+	forged := *alice
+	forged.token = StaticToken("a.b.c")
+	if _, err := forged.Get(ctx, "k"); !errors.Is(err, ErrUnauthorized) || !errors.As(err, &e) || e.Code == "" {
+		t.Errorf("TestClientErrors token was incorrect, got: <%v>, want: <%v>.", err, ErrUnauthorized)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch {
+		case r.URL.Path == "/busy" && calls < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/limited" && calls < 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`"#V0020"`))
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{"key":"k","value":"v"}`))
+		}
+	}))
+	defer srv.Close()
+	c, err := New(Config{Addr: srv.URL, Token: StaticToken("t"), Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"busy", "limited"} {
+		calls = 0
+		if _, err := c.Get(context.Background(), name); err != nil {
+			t.Errorf("TestRetry %s was incorrect, got: <%v>, want: <nil>.", name, err)
+		}
+	}
+	calls = 0
+	if err := c.Put(context.Background(), "k", "v"); !errors.Is(err, ErrUnavailable) || calls != 1 {
+		t.Errorf("TestRetry POST was incorrect, got: <%v> after %d calls, want: <%v> after 1 call.", err, calls, ErrUnavailable)
+	}
+	// the used one-time token was not recorded, the vault is unavailable
+	if err := newError(http.MethodGet, "/k", http.StatusServiceUnavailable, []byte(`"#V0026"`)); !errors.Is(err, ErrUnavailable) || err.retryable() {
+		t.Errorf("TestRetry #V0026 was incorrect, got: <%v %t>, want: <%v false>.", err, err.retryable(), ErrUnavailable)
+	}
+}
+
+// a one-time token may have been used by a request cut by a network error, it
+// is not sent again.
+func TestRetryOneTime(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer srv.Close()
+	payload := func(claims string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+	}
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{payload(`{"sub":"alice","once":true}`), 1},
+		{payload(`{"sub":"alice"}`), 3},
+		{"opaque", 3},
+	}
+	for _, tt := range tests {
+		c, err := New(Config{Addr: srv.URL, Token: StaticToken(tt.token), Retries: 2, Backoff: time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, method := range []string{http.MethodPut, http.MethodDelete} {
+			calls = 0
+			if method == http.MethodPut {
+				err = c.Update(context.Background(), "k", "v")
+			} else {
+				err = c.Delete(context.Background(), "k")
+			}
+			if err == nil || calls != tt.want {
+				t.Errorf("TestRetryOneTime %s %s was incorrect, got: <%v> after %d calls, want: <%d calls>.", method, tt.token, err, calls, tt.want)
+			}
+		}
+	}
+}
+
+func TestFileToken(t *testing.T) {
+	f, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	src := FileToken(f.Name())
+	for _, want := range []string{"first", "second"} {
+		ioutil.WriteFile(f.Name(), []byte(want+"\n"), 0600)
+		if got, err := src.Token(); err != nil || got != want {
+			t.Errorf("TestFileToken was incorrect, got: <%s %v>, want: <%s>.", got, err, want)
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	login := vault(t, configuration.Configuration{Limits: configuration.LimitConf{MaxFailures: 2}})
+	alice, bob := login("alice", "alicekey"), login("bob", "bobkey")
+	alice.Put(ctx, "db-pass", "s3cret")
+	alice.Put(ctx, "prod/sql", "first")
+	alice.Update(ctx, "prod/sql", "p@ss")
+	bundle, err := alice.Export(ctx, "bundle-pass")
+	if err != nil {
+		t.Fatalf("TestExportImport Export failed: %v", err)
+	}
+	// a wrong bundle passphrase is not a wrong vault key, bob is not locked out
+	for i := 0; i < 3; i++ {
+		if _, err := bob.Import(ctx, bundle, "wrong-pass", "", false); !errors.Is(err, ErrWrongKey) {
+			t.Errorf("TestExportImport bundle key was incorrect, got: <%v>, want: <%v>.", err, ErrWrongKey)
+		}
+	}
+	bob.Put(ctx, "db-pass", "mine")
+	r, err := bob.Import(ctx, bundle, "bundle-pass", ImportSkip, true)
+	if err != nil || len(r.Created) != 1 || len(r.Skipped) != 1 {
+		t.Errorf("TestExportImport dry run was incorrect, got: <%+v %v>.", r, err)
+	}
+	if list, _ := bob.List(ctx); len(list) != 1 {
+		t.Errorf("TestExportImport dry run wrote <%d> secrets, want: <1>.", len(list))
+	}
+	r, err = bob.Import(ctx, bundle, "bundle-pass", ImportFail, false)
+	var e *Error
+	if !errors.As(err, &e) || e.Status != http.StatusConflict || len(r.Conflicts) != 1 || r.Conflicts[0] != "db-pass" {
+		t.Errorf("TestExportImport fail mode was incorrect, got: <%+v %v>.", r, err)
+	}
+	if list, _ := bob.List(ctx); len(list) != 1 {
+		t.Errorf("TestExportImport fail mode wrote <%d> secrets, want: <1>.", len(list))
+	}
+	if r, err = bob.Import(ctx, bundle, "bundle-pass", ImportOverwrite, false); err != nil || len(r.Created) != 1 || len(r.Overwritten) != 1 {
+		t.Errorf("TestExportImport overwrite was incorrect, got: <%+v %v>.", r, err)
+	}
+	for key, want := range map[string]string{"db-pass": "s3cret", "prod/sql": "p@ss"} {
+		if s, err := bob.Get(ctx, key); err != nil || s.Value != want {
+			t.Errorf("TestExportImport %s was incorrect, got: <%s %v>, want: <%s>.", key, s.Value, err, want)
+		}
+	}
+	// a created secret get the versions of the bundle, an overwritten one keep its own
+	for key, want := range map[string][]string{"db-pass": {"s3cret", "mine"}, "prod/sql": {"p@ss", "first"}} {
+		versions, err := bob.Versions(ctx, key)
+		if err != nil || len(versions) != len(want) {
+			t.Errorf("TestExportImport %s versions was incorrect, got: <%+v %v>, want: <%v>.", key, versions, err, want)
+			continue
+		}
+		for i, v := range versions {
+			if v.Value != want[i] {
+				t.Errorf("TestExportImport %s version %d was incorrect, got: <%s>, want: <%s>.", key, i, v.Value, want[i])
+			}
+		}
+	}
+}
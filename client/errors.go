@@ -0,0 +1,141 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The errors returned by the Client, test them with errors.Is.
+var (
+	ErrNotFound     = errors.New("secret not found or not opened by the key")
+	ErrUnauthorized = errors.New("token refused")
+	ErrForbidden    = errors.New("access denied")
+	ErrWrongKey     = errors.New("wrong vault key")
+	ErrLocked       = errors.New("secret locked by an administrator")
+	ErrRateLimited  = errors.New("too many requests")
+	ErrLockedOut    = errors.New("locked out after failed decryptions")
+	ErrTooLarge     = errors.New("request or value too large")
+	ErrUnavailable  = errors.New("vault unavailable")
+)
+
+// codes map the #V codes of the vault to the client errors.
+var codes = map[string]error{
+	"#V0001": ErrUnauthorized,
+	"#V0002": ErrUnauthorized,
+	"#V0003": ErrUnauthorized,
+	"#V0004": ErrUnauthorized,
+	"#V0005": ErrUnauthorized,
+	"#V0009": ErrUnauthorized,
+	"#V0010": ErrUnauthorized,
+	"#V0011": ErrUnauthorized,
+	"#V0012": ErrUnauthorized,
+	"#V0013": ErrUnauthorized,
+	"#V0014": ErrUnauthorized,
+	"#V0015": ErrForbidden,
+	"#V0016": ErrForbidden,
+	"#V0017": ErrWrongKey,
+	"#V0018": ErrLocked,
+	"#V0019": ErrUnavailable,
+	"#V0020": ErrRateLimited,
+	"#V0021": ErrLockedOut,
+	"#V0022": ErrForbidden,
+	"#V0023": ErrTooLarge,
+	"#V0024": ErrTooLarge,
+	"#V0025": ErrWrongKey,
+	"#V0026": ErrUnavailable,
+}
+
+// Error is a request refused by the vault. Code is the #V code of the answer,
+// if any.
+type Error struct {
+	Method  string
+	Path    string
+	Status  int
+	Code    string
+	Message string
+	err     error
+	body    []byte
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("ezb_vault: %s %s: %d", e.Method, e.Path, e.Status)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.err != nil {
+		return msg + " " + e.err.Error()
+	}
+	if e.Message != "" {
+		return msg + " " + e.Message
+	}
+	return msg
+}
+
+// Unwrap return the Err* value of the code or the status.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// newError read the #V code or the message of an error answer.
+func newError(method, path string, status int, body []byte) *Error {
+	e := &Error{Method: method, Path: path, Status: status, body: body}
+	var msg string
+	if json.Unmarshal(body, &msg) != nil {
+		msg = strings.TrimSpace(string(body))
+	}
+	if strings.HasPrefix(msg, "#V") {
+		e.Code = msg
+		e.err = codes[msg]
+	} else {
+		e.Message = msg
+	}
+	if e.err == nil {
+		switch status {
+		case http.StatusNoContent, http.StatusNotFound:
+			e.err = ErrNotFound
+		case http.StatusUnauthorized:
+			e.err = ErrUnauthorized
+		case http.StatusForbidden:
+			e.err = ErrForbidden
+		case http.StatusLocked:
+			e.err = ErrLocked
+		case http.StatusTooManyRequests:
+			e.err = ErrRateLimited
+		case http.StatusRequestEntityTooLarge:
+			e.err = ErrTooLarge
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			e.err = ErrUnavailable
+		}
+	}
+	return e
+}
+
+// retryable tell if the request can be sent again. A POST is retried only
+// when it was refused before reaching the handler.
+func (e *Error) retryable() bool {
+	switch {
+	case e.Code == "#V0020":
+		return true
+	case e.Code != "" || e.Method == http.MethodPost:
+		return false
+	}
+	return e.Status == http.StatusBadGateway || e.Status == http.StatusServiceUnavailable || e.Status == http.StatusGatewayTimeout
+}
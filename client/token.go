@@ -0,0 +1,64 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// TokenSource give the jwt of each request, so a token refreshed by another
+// process is picked up.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// TokenFunc is a TokenSource function.
+type TokenFunc func() (string, error)
+
+func (f TokenFunc) Token() (string, error) {
+	return f()
+}
+
+// StaticToken always give the same token.
+func StaticToken(token string) TokenSource {
+	return TokenFunc(func() (string, error) {
+		return token, nil
+	})
+}
+
+// FileToken read the token from file at each request.
+func FileToken(file string) TokenSource {
+	return TokenFunc(func() (string, error) {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(raw)), nil
+	})
+}
+
+// EnvToken read the token from an environment variable at each request.
+func EnvToken(name string) TokenSource {
+	return TokenFunc(func() (string, error) {
+		if t := os.Getenv(name); t != "" {
+			return t, nil
+		}
+		return "", fmt.Errorf("%s is not set", name)
+	})
+}
@@ -0,0 +1,161 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ezbastion/ezb_vault/Middleware"
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/urfave/cli"
+)
+
+// configCommand check the configuration before a start or a reload.
+func configCommand() cli.Command {
+	return cli.Command{
+		Name:  "config",
+		Usage: "Check the configuration.",
+		Subcommands: []cli.Command{
+			{
+				Name:  "check",
+				Usage: "Validate the configuration, the certificates, the database and the issuer keys.",
+				Action: func(c *cli.Context) error {
+					file := configuration.ConfFile(exPath)
+					conf, err := configuration.CheckConfig(true, exPath)
+					if err != nil {
+						return cli.NewExitError(fmt.Sprintf("%s: %s", file, err.Error()), 1)
+					}
+					if e := checkConfig(conf, exPath); len(e) > 0 {
+						for _, f := range e {
+							fmt.Println(f.Error())
+						}
+						return cli.NewExitError(fmt.Sprintf("%s: %d problem(s) found", file, len(e)), 1)
+					}
+					fmt.Printf("%s ok\n", file)
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// checkConfig validate conf and the files it use, every problem is returned.
+func checkConfig(conf configuration.Configuration, exPath string) configuration.Errors {
+	var e configuration.Errors
+	if err := conf.Validate(); err != nil {
+		e = append(e, err.(configuration.Errors)...)
+	}
+	var leaf *x509.Certificate
+	var chain [][]byte
+	if conf.PublicCert != "" && conf.PrivateKey != "" {
+		certs := &certStore{}
+		if err := certs.Load(conf, exPath); err != nil {
+			e.Add("publiccert", "%s", err.Error())
+		} else {
+			leaf = certs.Leaf()
+			cert, _ := certs.GetCertificate(nil)
+			chain = cert.Certificate[1:]
+		}
+	}
+	if conf.CaCert != "" {
+		checkChain(&e, path.Join(exPath, conf.CaCert), leaf, chain)
+	}
+	if conf.DB != "" {
+		checkWritable(&e, "dbpath", path.Join(exPath, conf.DB))
+	}
+	checkIssuers(&e, conf, path.Join(exPath, "cert"))
+	return e
+}
+
+// checkChain verify the server certificate is signed by the CA file.
+func checkChain(e *configuration.Errors, caFile string, leaf *x509.Certificate, chain [][]byte) {
+	raw, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		e.Add("cacert", "%s", err.Error())
+		return
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(raw) {
+		e.Add("cacert", "no certificate found in %s", caFile)
+		return
+	}
+	if leaf == nil {
+		return
+	}
+	inter := x509.NewCertPool()
+	for _, der := range chain {
+		if c, err := x509.ParseCertificate(der); err == nil {
+			inter.AddCert(c)
+		}
+	}
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: inter, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	if _, err := leaf.Verify(opts); err != nil {
+		e.Add("cacert", "does not validate publiccert: %s", err.Error())
+	}
+}
+
+// checkWritable check the database file, or its folder for a new one, can be
+// written.
+func checkWritable(e *configuration.Errors, field, file string) {
+	if _, err := os.Stat(file); err == nil {
+		f, err := os.OpenFile(file, os.O_RDWR, 0)
+		if err != nil {
+			e.Add(field, "%s", err.Error())
+			return
+		}
+		f.Close()
+	}
+	f, err := ioutil.TempFile(filepath.Dir(file), ".ezb_vault")
+	if err != nil {
+		e.Add(field, "folder is not writable: %s", err.Error())
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// checkIssuers read the <iss>.crt token issuer keys of dir, as AuthJWT does.
+func checkIssuers(e *configuration.Errors, conf configuration.Configuration, dir string) {
+	own := map[string]bool{}
+	for _, f := range []string{conf.PublicCert, conf.CaCert} {
+		if f != "" {
+			own[filepath.Base(f)] = true
+		}
+	}
+	files, _ := filepath.Glob(path.Join(dir, "*.crt"))
+	keys := Middleware.NewIssuerKeys(dir)
+	found := 0
+	for _, f := range files {
+		name := filepath.Base(f)
+		if own[name] {
+			continue
+		}
+		if _, err := keys.Get(strings.TrimSuffix(name, ".crt")); err != nil {
+			e.Add("cert/"+name, "not an issuer ecdsa public key: %s", err.Error())
+			continue
+		}
+		found++
+	}
+	if found == 0 {
+		e.Add("cert", "no issuer key <iss>.crt in %s, every token would be refused", dir)
+	}
+}
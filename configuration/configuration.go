@@ -19,7 +19,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 type Configuration struct {
@@ -28,27 +32,250 @@ type Configuration struct {
 	PublicCert      string   `json:"publiccert"`
 	CaCert          string   `json:"cacert"`
 	// StaPath         string   `json:"stapath"`
-	DB              string   `json:"dbpath"`
-	ServiceName     string   `json:"servicename"`
-	ServiceFullName string   `json:"servicefullname"`
-	LogLevel        string   `json:"loglevel"`
-	LogPath         string   `json:"logpath"`
-	EzbPki          string   `json:"ezb_pki"`
-	ReportCaller    bool     `json:"reportcaller"`
-	JsonToStdout    bool     `json:"jsonstdout"`
-	SAN             []string `json:"san"`
+	DB              string      `json:"dbpath"`
+	ServiceName     string      `json:"servicename"`
+	ServiceFullName string      `json:"servicefullname"`
+	LogLevel        string      `json:"loglevel"`
+	LogPath         string      `json:"logpath"`
+	EzbPki          string      `json:"ezb_pki"`
+	ReportCaller    bool        `json:"reportcaller"`
+	JsonToStdout    bool        `json:"jsonstdout"`
+	SAN             []string    `json:"san"`
+	OneTimeUse      bool        `json:"onetimeuse"`
+	GroupClaim      string      `json:"groupclaim"`
+	Admins          []string    `json:"admins"`
+	AdminGroups     []string    `json:"admingroups"`
+	AuditPath       string      `json:"auditpath"`
+	Audit           AuditConf   `json:"audit"`
+	ShutdownTimeout int         `json:"shutdowntimeout"`
+	TLS             TLSConf     `json:"tls"`
+	Renew           RenewConf   `json:"renew"`
+	Metrics         MetricsConf `json:"metrics"`
+	Limits          LimitConf   `json:"limits"`
+	CORS            CORSConf    `json:"cors"`
+	MaxBody         int64       `json:"maxbody"`
+	MaxValue        int         `json:"maxvalue"`
 }
 
+// MaxBodySize return the largest request body in bytes, 1 MB by default.
+func (conf Configuration) MaxBodySize() int64 {
+	if conf.MaxBody <= 0 {
+		return 1 << 20
+	}
+	return conf.MaxBody
+}
+
+// MaxValueSize return the largest secret value in bytes, 64 KB by default.
+func (conf Configuration) MaxValueSize() int {
+	if conf.MaxValue <= 0 {
+		return 64 << 10
+	}
+	return conf.MaxValue
+}
+
+// CORSConf list the browser origins allowed to call the vault, "*" for any.
+// CORS is disabled without origin. Methods and Headers default to the ones
+// used by the api.
+type CORSConf struct {
+	Origins     []string `json:"origins,omitempty"`
+	Methods     []string `json:"methods,omitempty"`
+	Headers     []string `json:"headers,omitempty"`
+	Expose      []string `json:"expose,omitempty"`
+	Credentials bool     `json:"credentials,omitempty"`
+	MaxAge      int      `json:"maxage,omitempty"`
+}
+
+// Allow tell if origin is allowed, and if it is by "*".
+func (c CORSConf) Allow(origin string) (allowed bool, wildcard bool) {
+	for _, o := range c.Origins {
+		if o == "*" {
+			return true, true
+		}
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// AllowedMethods return the methods of the preflight answer.
+func (c CORSConf) AllowedMethods() []string {
+	if len(c.Methods) == 0 {
+		return []string{"GET", "POST", "PUT", "DELETE"}
+	}
+	return c.Methods
+}
+
+// AllowedHeaders return the request headers of the preflight answer.
+func (c CORSConf) AllowedHeaders() []string {
+	if len(c.Headers) == 0 {
+		return []string{"Authorization", "Content-Type", "EZB-VAULT-KEY", "EZB-VAULT-SHARE-KEY", "EZB-VAULT-BUNDLE-KEY"}
+	}
+	return c.Headers
+}
+
+// LimitConf set the request rates per subject and per address (requests per
+// second, 0 for no limit) and the lockout after MaxFailures wrong keys in
+// FailureWindow seconds (5 in 300 by default, negative to disable), for
+// Lockout seconds (900 by default). An address is locked out only after
+// IPMaxFailures wrong keys, of any subject (0 by default, never).
+type LimitConf struct {
+	Rate          float64 `json:"rate,omitempty"`
+	Burst         int     `json:"burst,omitempty"`
+	IPRate        float64 `json:"iprate,omitempty"`
+	IPBurst       int     `json:"ipburst,omitempty"`
+	MaxFailures   int     `json:"maxfailures,omitempty"`
+	IPMaxFailures int     `json:"ipmaxfailures,omitempty"`
+	FailureWindow int     `json:"failurewindow,omitempty"`
+	Lockout       int     `json:"lockout,omitempty"`
+}
+
+func burst(burst int, rate float64) int {
+	if burst > 0 {
+		return burst
+	}
+	if b := int(2 * rate); b > 1 {
+		return b
+	}
+	return 1
+}
+
+// BurstSize return the subject burst, twice the rate by default.
+func (l LimitConf) BurstSize() int {
+	return burst(l.Burst, l.Rate)
+}
+
+// IPBurstSize return the address burst, twice the rate by default.
+func (l LimitConf) IPBurstSize() int {
+	return burst(l.IPBurst, l.IPRate)
+}
+
+// MaxFails return the failed decryptions before lockout.
+func (l LimitConf) MaxFails() int {
+	if l.MaxFailures <= 0 {
+		return 5
+	}
+	return l.MaxFailures
+}
+
+// Window return how long a failed decryption is counted.
+func (l LimitConf) Window() time.Duration {
+	if l.FailureWindow <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(l.FailureWindow) * time.Second
+}
+
+// LockoutDuration return how long a subject or address stay locked out.
+func (l LimitConf) LockoutDuration() time.Duration {
+	if l.Lockout <= 0 {
+		return 15 * time.Minute
+	}
+	return time.Duration(l.Lockout) * time.Second
+}
+
+// MetricsConf expose /sys/metrics, without token. Allow restrict it to these
+// addresses or CIDR, any address if empty.
+type MetricsConf struct {
+	Disabled bool     `json:"disabled,omitempty"`
+	Allow    []string `json:"allow,omitempty"`
+}
+
+// RenewConf set when the certificate is renewed from EzbPki: Before days
+// before expiry (30 by default), for Days days (730 by default).
+type RenewConf struct {
+	Disabled bool `json:"disabled,omitempty"`
+	Before   int  `json:"before,omitempty"`
+	Days     int  `json:"days,omitempty"`
+}
+
+// Threshold return how long before expiry the certificate is renewed.
+func (r RenewConf) Threshold() time.Duration {
+	if r.Before <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return time.Duration(r.Before) * 24 * time.Hour
+}
+
+// Validity return the validity in days asked to the pki.
+func (r RenewConf) Validity() int {
+	if r.Days <= 0 {
+		return 730
+	}
+	return r.Days
+}
+
+// AuditConf list the audit sinks. Without sink, records go to AuditPath.
+// With FailClosed, a request fail if no sink accepted its record.
+type AuditConf struct {
+	FailClosed bool        `json:"failclosed"`
+	Sinks      []AuditSink `json:"sinks"`
+}
+
+// AuditSink is one audit destination, Type is file, rotate, syslog or http.
+type AuditSink struct {
+	Type     string            `json:"type"`
+	Path     string            `json:"path,omitempty"`
+	MaxSize  int               `json:"maxsize,omitempty"`
+	MaxFiles int               `json:"maxfiles,omitempty"`
+	Network  string            `json:"network,omitempty"`
+	Address  string            `json:"address,omitempty"`
+	URL      string            `json:"url,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Buffer   int               `json:"buffer,omitempty"`
+	Timeout  int               `json:"timeout,omitempty"`
+}
+
+// ShutdownWait return how long the requests in flight are waited for on stop,
+// 30 seconds by default.
+func (conf Configuration) ShutdownWait() time.Duration {
+	if conf.ShutdownTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(conf.ShutdownTimeout) * time.Second
+}
+
+// AuditFile return the audit log path, log/audit.log by default.
+func (conf Configuration) AuditFile(exPath string) string {
+	if conf.AuditPath == "" {
+		return path.Join(exPath, "log", "audit.log")
+	}
+	if filepath.IsAbs(conf.AuditPath) {
+		return conf.AuditPath
+	}
+	return path.Join(exPath, conf.AuditPath)
+}
+
+// CheckConfig read the configuration, each source override the previous one:
+// the config file (see ConfFile), the EZB_VAULT_* variables, then the flags
+// given to SetSource. Without config file, the default one may be missing if
+// a variable or a flag is set.
 func CheckConfig(isIntSess bool, exPath string) (conf Configuration, err error) {
-	ConfFile := path.Join(exPath, "conf/config.json")
-	raw, err := ioutil.ReadFile(ConfFile)
+	file := ConfFile(exPath)
+	raw, rerr := ioutil.ReadFile(file)
+	missing := os.IsNotExist(rerr) && file == path.Join(exPath, "conf/config.json")
+	if rerr != nil && !missing {
+		fmt.Println("### error reading conf file " + file)
+		return conf, rerr
+	}
+	if !missing {
+		err = json.Unmarshal(raw, &conf)
+		if err != nil {
+			fmt.Println("### error Unmarshal json file " + file)
+			return conf, err
+		}
+	}
+	n, err := applyEnv(&conf, os.Environ())
 	if err != nil {
-		fmt.Println("### error reading conf file " + ConfFile)
+		fmt.Println("### error in environment " + err.Error())
 		return conf, err
 	}
-	err = json.Unmarshal(raw, &conf)
-	if err != nil {
-		fmt.Println("### error Unmarshal json file " + ConfFile)
+	if missing && n == 0 && len(source.flags) == 0 {
+		fmt.Println("### error reading conf file " + file)
+		return conf, rerr
+	}
+	if err = applyFlags(&conf, source.flags); err != nil {
+		fmt.Println("### error in flags " + err.Error())
 		return conf, err
 	}
 	return conf, nil
@@ -42,6 +42,6 @@ func InitDB(conf Configuration, exPath string) (*gorm.DB, error) {
 		db.CreateTable(&m.KeyVal{})
 		db.Model(&m.KeyVal{}).AddUniqueIndex("idx_keyval_id", "id")
 	}
-	db.AutoMigrate(&m.KeyVal{})
+	db.AutoMigrate(&m.KeyVal{}, &m.Revocation{}, &m.UsedToken{}, &m.Policy{}, &m.Share{}, &m.Version{})
 	return db, nil
 }
@@ -0,0 +1,72 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"sync"
+)
+
+// Live hold the configuration of the running server, Reload read config.json
+// again and give it to the reload hooks before it is used.
+type Live struct {
+	mu     sync.RWMutex
+	reload sync.Mutex
+	conf   Configuration
+	exPath string
+	hooks  []func(Configuration) error
+}
+
+func NewLive(conf Configuration, exPath string) *Live {
+	return &Live{conf: conf, exPath: exPath}
+}
+
+// Get return the current configuration.
+func (l *Live) Get() Configuration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.conf
+}
+
+// OnReload add a hook, called in order by Reload. A hook error cancel the
+// reload, the hooks already called must then keep working with the old values.
+func (l *Live) OnReload(fn func(Configuration) error) {
+	l.reload.Lock()
+	defer l.reload.Unlock()
+	l.hooks = append(l.hooks, fn)
+}
+
+// Reload read config.json, validate it, run the hooks and switch to the new
+// configuration.
+func (l *Live) Reload() error {
+	l.reload.Lock()
+	defer l.reload.Unlock()
+	conf, err := CheckConfig(false, l.exPath)
+	if err != nil {
+		return err
+	}
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+	for _, fn := range l.hooks {
+		if err := fn(conf); err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	l.conf = conf
+	l.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,83 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Reload read config.json again, run the hooks in order and switch to the new
+// configuration, a hook error or an invalid file keep the old one.
+func TestLiveReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "conf"), 0700)
+	write := func(raw string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, "conf", "config.json"), []byte(raw), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := Configuration{Listen: "0.0.0.0:5100", PublicCert: "cert/ezb_vault.crt", PrivateKey: "cert/ezb_vault.key", DB: "db/ezb_vault.db", LogLevel: "info"}
+	live := NewLive(old, dir)
+	var calls []string
+	var fail error
+	live.OnReload(func(c Configuration) error {
+		calls = append(calls, "first:"+c.LogLevel)
+		return fail
+	})
+	live.OnReload(func(c Configuration) error {
+		calls = append(calls, "second:"+c.LogLevel)
+		return nil
+	})
+
+	write(`{"listen":"0.0.0.0:5100","publiccert":"cert/ezb_vault.crt","privatekey":"cert/ezb_vault.key","dbpath":"db/ezb_vault.db","loglevel":"debug"}`)
+	if err := live.Reload(); err != nil {
+		t.Fatalf("TestLiveReload was incorrect, got: <%v>, want: <%v>.", err, nil)
+	}
+	if got := live.Get().LogLevel; got != "debug" {
+		t.Errorf("TestLiveReload was incorrect, got: <%s>, want: <%s>.", got, "debug")
+	}
+	if len(calls) != 2 || calls[0] != "first:debug" || calls[1] != "second:debug" {
+		t.Errorf("TestLiveReload hooks were incorrect, got: <%v>, want: <%v>.", calls, []string{"first:debug", "second:debug"})
+	}
+
+	calls = nil
+	fail = errors.New("refused")
+	write(`{"listen":"0.0.0.0:5100","publiccert":"cert/ezb_vault.crt","privatekey":"cert/ezb_vault.key","dbpath":"db/ezb_vault.db","loglevel":"trace"}`)
+	if err := live.Reload(); err != fail {
+		t.Errorf("TestLiveReload hook error was incorrect, got: <%v>, want: <%v>.", err, fail)
+	}
+	if len(calls) != 1 || live.Get().LogLevel != "debug" {
+		t.Errorf("TestLiveReload hook error was incorrect, got: <%v %s>, want: <[first:trace] debug>.", calls, live.Get().LogLevel)
+	}
+
+	calls = nil
+	fail = nil
+	write(`{"listen":"0.0.0.0","publiccert":"cert/ezb_vault.crt","privatekey":"cert/ezb_vault.key","dbpath":"db/ezb_vault.db","loglevel":"warning"}`)
+	if err := live.Reload(); err == nil {
+		t.Errorf("TestLiveReload invalid was incorrect, got: <%v>, want an error.", err)
+	}
+	if len(calls) != 0 || live.Get().LogLevel != "debug" {
+		t.Errorf("TestLiveReload invalid was incorrect, got: <%v %s>, want: <[] debug>.", calls, live.Get().LogLevel)
+	}
+}
@@ -0,0 +1,220 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix start the environment variables overriding config.json, the
+// field path is upper-cased and its dots are replaced by "_", like
+// EZB_VAULT_LISTEN or EZB_VAULT_TLS_MINVERSION. EZB_VAULT_CONFIG is the
+// config file.
+const EnvPrefix = "EZB_VAULT_"
+
+var source struct {
+	file  string
+	flags map[string]string
+}
+
+// SetSource set the config file ("" for the default) and the flag values,
+// by field path, used by CheckConfig. The server keep them for its reloads.
+func SetSource(file string, flags map[string]string) {
+	if file != "" {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+	}
+	source.file = file
+	source.flags = flags
+}
+
+// ConfFile return the config file: --config, then EZB_VAULT_CONFIG, then
+// conf/config.json next to the executable.
+func ConfFile(exPath string) string {
+	if source.file != "" {
+		return source.file
+	}
+	if f := os.Getenv(EnvPrefix + "CONFIG"); f != "" {
+		return f
+	}
+	return path.Join(exPath, "conf/config.json")
+}
+
+// Fields return the path of every field that can be overridden, the nested
+// fields are joined with a dot: "listen", "tls.minversion".
+func Fields() []string {
+	var out []string
+	walk(reflect.TypeOf(Configuration{}), "", func(p string, t reflect.Type) {
+		if t.Kind() != reflect.Struct {
+			out = append(out, p)
+		}
+	})
+	return out
+}
+
+// EnvName return the environment variable of a field path.
+func EnvName(field string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(field, ".", "_", -1))
+}
+
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+// walk call fn on every field of t, the structs before their own fields.
+func walk(t reflect.Type, prefix string, fn func(string, reflect.Type)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := jsonName(f)
+		if name == "" || f.PkgPath != "" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		fn(name, f.Type)
+		if f.Type.Kind() == reflect.Struct {
+			walk(f.Type, name, fn)
+		}
+	}
+}
+
+// Override set one field by its path. Lists are comma separated, structs,
+// maps and lists of structs are given in json.
+func Override(conf *Configuration, field, value string) error {
+	v := reflect.ValueOf(conf).Elem()
+	for _, name := range strings.Split(strings.ToLower(field), ".") {
+		if v.Kind() != reflect.Struct {
+			return fmt.Errorf("unknown configuration field %s", field)
+		}
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Type().Field(i); f.PkgPath == "" && jsonName(f) == name {
+				v = v.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown configuration field %s", field)
+		}
+	}
+	if err := setValue(v, value); err != nil {
+		return fmt.Errorf("invalid value for %s: %s", field, err.Error())
+	}
+	return nil
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			var list []string
+			for _, s := range strings.Split(value, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					list = append(list, s)
+				}
+			}
+			v.Set(reflect.ValueOf(list))
+			return nil
+		}
+		return json.Unmarshal([]byte(value), v.Addr().Interface())
+	default:
+		return json.Unmarshal([]byte(value), v.Addr().Interface())
+	}
+	return nil
+}
+
+// applyEnv override conf with the EZB_VAULT_* variables of environ and return
+// how many were used. A struct is set before its fields, unknown variables are
+// left to the other tools.
+func applyEnv(conf *Configuration, environ []string) (int, error) {
+	fields := make(map[string]string)
+	var order []string
+	walk(reflect.TypeOf(Configuration{}), "", func(p string, t reflect.Type) {
+		fields[EnvName(p)] = p
+		order = append(order, p)
+	})
+	set := make(map[string]string)
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasPrefix(kv, EnvPrefix) {
+			continue
+		}
+		if p, ok := fields[kv[:i]]; ok {
+			set[p] = kv[i+1:]
+		}
+	}
+	for _, p := range order {
+		if value, ok := set[p]; ok {
+			if err := Override(conf, p, value); err != nil {
+				return 0, fmt.Errorf("%s: %s", EnvName(p), err.Error())
+			}
+		}
+	}
+	return len(set), nil
+}
+
+// applyFlags override conf with the flag values, sorted by path.
+func applyFlags(conf *Configuration, flags map[string]string) error {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := Override(conf, name, flags[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
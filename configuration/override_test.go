@@ -0,0 +1,100 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverride(t *testing.T) {
+	var conf Configuration
+	env := []string{
+		"PATH=/bin",
+		"EZB_VAULT_LISTEN=0.0.0.0:5100",
+		"EZB_VAULT_EZB_PKI=pki.local:5010",
+		"EZB_VAULT_ADMINS=alice, bob",
+		"EZB_VAULT_TLS_MINVERSION=1.3",
+		"EZB_VAULT_LIMITS_RATE=2.5",
+		"EZB_VAULT_ONETIMEUSE=true",
+		"EZB_VAULT_AUDIT={\"failclosed\":true}",
+		"EZB_VAULT_AUDIT_SINKS=[{\"type\":\"syslog\",\"address\":\"log.local:514\"}]",
+		"EZB_VAULT_ADDR=https://vault.local:5100",
+	}
+	n, err := applyEnv(&conf, env)
+	if err != nil {
+		t.Fatalf("TestOverride env failed: %v", err)
+	}
+	if n != 8 {
+		t.Errorf("TestOverride count was incorrect, got: <%d>, want: <%d>.", n, 8)
+	}
+	if conf.Listen != "0.0.0.0:5100" || conf.EzbPki != "pki.local:5010" || conf.TLS.MinVersion != "1.3" || conf.Limits.Rate != 2.5 || !conf.OneTimeUse {
+		t.Errorf("TestOverride was incorrect, got: <%+v>.", conf)
+	}
+	if len(conf.Admins) != 2 || conf.Admins[1] != "bob" {
+		t.Errorf("TestOverride list was incorrect, got: <%v>, want: <%v>.", conf.Admins, []string{"alice", "bob"})
+	}
+	if !conf.Audit.FailClosed || len(conf.Audit.Sinks) != 1 || conf.Audit.Sinks[0].Address != "log.local:514" {
+		t.Errorf("TestOverride audit was incorrect, got: <%+v>.", conf.Audit)
+	}
+	if err := applyFlags(&conf, map[string]string{"listen": "127.0.0.1:5101"}); err != nil || conf.Listen != "127.0.0.1:5101" {
+		t.Errorf("TestOverride flag was incorrect, got: <%s>, want: <%s>.", conf.Listen, "127.0.0.1:5101")
+	}
+	for _, bad := range [][2]string{{"nope", "x"}, {"limits.rate", "fast"}, {"tls.minversion.x", "1"}} {
+		if err := Override(&conf, bad[0], bad[1]); err == nil {
+			t.Errorf("TestOverride %s was incorrect, got: <nil>, want an error.", bad[0])
+		}
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetSource("", nil)
+	file := filepath.Join(dir, "vault.json")
+	ioutil.WriteFile(file, []byte(`{"listen":"file:1","dbpath":"db/file.db","loglevel":"info"}`), 0600)
+	os.Setenv("EZB_VAULT_DBPATH", "db/env.db")
+	os.Setenv("EZB_VAULT_LOGLEVEL", "warning")
+	defer os.Unsetenv("EZB_VAULT_DBPATH")
+	defer os.Unsetenv("EZB_VAULT_LOGLEVEL")
+	SetSource(file, map[string]string{"loglevel": "debug"})
+	conf, err := CheckConfig(false, dir)
+	if err != nil {
+		t.Fatalf("TestConfigPrecedence failed: %v", err)
+	}
+	got := []string{conf.Listen, conf.DB, conf.LogLevel}
+	want := []string{"file:1", "db/env.db", "debug"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("TestConfigPrecedence was incorrect, got: <%v>, want: <%v>.", got, want)
+			break
+		}
+	}
+	// without config.json, the variables are enough
+	SetSource("", nil)
+	if conf, err = CheckConfig(false, dir); err != nil || conf.DB != "db/env.db" {
+		t.Errorf("TestConfigPrecedence without file was incorrect, got: <%v>, want: <%v>.", err, nil)
+	}
+	SetSource(filepath.Join(dir, "missing.json"), nil)
+	if _, err = CheckConfig(false, dir); err == nil {
+		t.Errorf("TestConfigPrecedence missing --config was incorrect, got: <nil>, want an error.")
+	}
+}
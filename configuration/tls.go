@@ -0,0 +1,112 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// TLSConf harden the listener. MinVersion is 1.2 by default, CipherSuites
+// (Go names, TLS 1.2 only) and Curves default to the Go ones. ClientAuth is
+// none, request, verify (if given) or require, client certificates are checked
+// against CaCert. OCSPStaple is a DER OCSP response file, refreshed by an
+// external tool.
+type TLSConf struct {
+	MinVersion   string   `json:"minversion,omitempty"`
+	CipherSuites []string `json:"ciphersuites,omitempty"`
+	Curves       []string `json:"curves,omitempty"`
+	ClientAuth   string   `json:"clientauth,omitempty"`
+	OCSPStaple   string   `json:"ocspstaple,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+var clientAuths = map[string]tls.ClientAuthType{
+	"":        tls.NoClientCert,
+	"none":    tls.NoClientCert,
+	"request": tls.RequestClientCert,
+	"verify":  tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+// TLSConfig build the listener settings, the certificate itself is given by
+// GetCertificate.
+func (conf Configuration) TLSConfig(exPath string) (*tls.Config, error) {
+	t := conf.TLS
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.MinVersion != "" {
+		v, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("tls minversion must be 1.2 or 1.3, got '%s'", t.MinVersion)
+		}
+		c.MinVersion = v
+	}
+	if len(t.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, s := range tls.CipherSuites() {
+			suites[s.Name] = s.ID
+		}
+		for _, name := range t.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("tls cipher suite '%s' is unknown or insecure", name)
+			}
+			c.CipherSuites = append(c.CipherSuites, id)
+		}
+	}
+	for _, name := range t.Curves {
+		id, ok := tlsCurves[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("tls curve '%s' is unknown, use X25519, P256, P384 or P521", name)
+		}
+		c.CurvePreferences = append(c.CurvePreferences, id)
+	}
+	auth, ok := clientAuths[strings.ToLower(t.ClientAuth)]
+	if !ok {
+		return nil, fmt.Errorf("tls clientauth must be none, request, verify or require, got '%s'", t.ClientAuth)
+	}
+	c.ClientAuth = auth
+	if auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert {
+		if conf.CaCert == "" {
+			return nil, fmt.Errorf("tls clientauth '%s' need cacert", t.ClientAuth)
+		}
+		raw, err := ioutil.ReadFile(path.Join(exPath, conf.CaCert))
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("no certificate found in %s", conf.CaCert)
+		}
+		c.ClientCAs = pool
+	}
+	return c, nil
+}
@@ -0,0 +1,104 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA write a self-signed ca.crt and a bad.crt without certificate in a new
+// directory.
+func testCA(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "ca.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "bad.crt"), []byte("not a certificate"), 0600)
+	return dir
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := testCA(t)
+	suite := tls.CipherSuites()[0]
+	tests := []struct {
+		name  string
+		ca    string
+		tls   TLSConf
+		ok    bool
+		check func(c *tls.Config) bool
+	}{
+		{"default", "", TLSConf{}, true, func(c *tls.Config) bool {
+			return c.MinVersion == tls.VersionTLS12 && c.ClientAuth == tls.NoClientCert && c.CipherSuites == nil && c.CurvePreferences == nil
+		}},
+		{"1.3", "", TLSConf{MinVersion: "1.3"}, true, func(c *tls.Config) bool { return c.MinVersion == tls.VersionTLS13 }},
+		{"1.1", "", TLSConf{MinVersion: "1.1"}, false, nil},
+		{"suite", "", TLSConf{CipherSuites: []string{suite.Name}}, true, func(c *tls.Config) bool {
+			return len(c.CipherSuites) == 1 && c.CipherSuites[0] == suite.ID
+		}},
+		{"insecure suite", "", TLSConf{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, false, nil},
+		{"curves", "", TLSConf{Curves: []string{"x25519", "P384"}}, true, func(c *tls.Config) bool {
+			return len(c.CurvePreferences) == 2 && c.CurvePreferences[0] == tls.X25519 && c.CurvePreferences[1] == tls.CurveP384
+		}},
+		{"unknown curve", "", TLSConf{Curves: []string{"P224"}}, false, nil},
+		{"unknown clientauth", "", TLSConf{ClientAuth: "always"}, false, nil},
+		{"request", "", TLSConf{ClientAuth: "Request"}, true, func(c *tls.Config) bool {
+			return c.ClientAuth == tls.RequestClientCert && c.ClientCAs == nil
+		}},
+		{"verify without cacert", "", TLSConf{ClientAuth: "verify"}, false, nil},
+		{"require", "ca.crt", TLSConf{ClientAuth: "require"}, true, func(c *tls.Config) bool {
+			return c.ClientAuth == tls.RequireAndVerifyClientCert && c.ClientCAs != nil
+		}},
+		{"require bad cacert", "bad.crt", TLSConf{ClientAuth: "require"}, false, nil},
+		{"require missing cacert", "missing.crt", TLSConf{ClientAuth: "require"}, false, nil},
+	}
+	for _, tt := range tests {
+		c, err := Configuration{CaCert: tt.ca, TLS: tt.tls}.TLSConfig(dir)
+		if (err == nil) != tt.ok {
+			t.Errorf("TestTLSConfig %s was incorrect, got: <%v>, want: <ok %t>.", tt.name, err, tt.ok)
+			continue
+		}
+		if tt.ok && !tt.check(c) {
+			t.Errorf("TestTLSConfig %s was incorrect, got: <%+v>.", tt.name, c)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FieldError is a wrong value of one configuration field.
+type FieldError struct {
+	Field string
+	Msg   string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+// Errors is every problem found in a configuration.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msg := make([]string, 0, len(e))
+	for _, f := range e {
+		msg = append(msg, f.Error())
+	}
+	return strings.Join(msg, "\n")
+}
+
+// Add record a problem of field.
+func (e *Errors) Add(field, format string, a ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Msg: fmt.Sprintf(format, a...)})
+}
+
+func (e *Errors) positive(field string, v float64) {
+	if v < 0 {
+		e.Add(field, "must be positive, got %v", v)
+	}
+}
+
+// Err return nil without problem, so Errors can be returned as an error.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate check the values of conf, without reading any file. Every problem
+// is returned at once, as Errors.
+func (conf Configuration) Validate() error {
+	var e Errors
+	if conf.Listen == "" {
+		e.Add("listen", "is required")
+	} else if err := checkAddress(conf.Listen); err != nil {
+		e.Add("listen", "%s", err.Error())
+	}
+	if conf.EzbPki != "" {
+		if err := checkAddress(conf.EzbPki); err != nil {
+			e.Add("ezb_pki", "%s", err.Error())
+		}
+	}
+	if conf.PublicCert == "" {
+		e.Add("publiccert", "is required")
+	}
+	if conf.PrivateKey == "" {
+		e.Add("privatekey", "is required")
+	}
+	if conf.DB == "" {
+		e.Add("dbpath", "is required")
+	}
+	if conf.LogLevel != "" {
+		if _, err := log.ParseLevel(conf.LogLevel); err != nil {
+			e.Add("loglevel", "must be panic, fatal, error, warning, info, debug or trace, got '%s'", conf.LogLevel)
+		}
+	}
+	for i, name := range conf.SAN {
+		if strings.TrimSpace(name) == "" {
+			e.Add(fmt.Sprintf("san[%d]", i), "is empty")
+		}
+	}
+	e.positive("shutdowntimeout", float64(conf.ShutdownTimeout))
+	e.positive("maxbody", float64(conf.MaxBody))
+	e.positive("maxvalue", float64(conf.MaxValue))
+	if int64(conf.MaxValueSize()) > conf.MaxBodySize() {
+		e.Add("maxvalue", "is over maxbody (%d bytes)", conf.MaxBodySize())
+	}
+	conf.TLS.validate(conf, &e)
+	conf.Limits.validate(&e)
+	conf.CORS.validate(&e)
+	conf.Audit.validate(&e)
+	for i, a := range conf.Metrics.Allow {
+		if net.ParseIP(a) == nil {
+			if _, _, err := net.ParseCIDR(a); err != nil {
+				e.Add(fmt.Sprintf("metrics.allow[%d]", i), "'%s' is not an address or a CIDR", a)
+			}
+		}
+	}
+	e.positive("renew.before", float64(conf.Renew.Before))
+	e.positive("renew.days", float64(conf.Renew.Days))
+	return e.Err()
+}
+
+// checkAddress check a host:port address.
+func checkAddress(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("'%s' is not a host:port address", addr)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("port '%s' must be between 1 and 65535", port)
+	}
+	return nil
+}
+
+func (t TLSConf) validate(conf Configuration, e *Errors) {
+	if _, ok := tlsVersions[t.MinVersion]; t.MinVersion != "" && !ok {
+		e.Add("tls.minversion", "must be 1.2 or 1.3, got '%s'", t.MinVersion)
+	}
+	suites := make(map[string]bool)
+	for _, s := range tls.CipherSuites() {
+		suites[s.Name] = true
+	}
+	for _, name := range t.CipherSuites {
+		if !suites[name] {
+			e.Add("tls.ciphersuites", "'%s' is unknown or insecure", name)
+		}
+	}
+	for _, name := range t.Curves {
+		if _, ok := tlsCurves[strings.ToUpper(name)]; !ok {
+			e.Add("tls.curves", "'%s' is unknown, use X25519, P256, P384 or P521", name)
+		}
+	}
+	auth, ok := clientAuths[strings.ToLower(t.ClientAuth)]
+	if !ok {
+		e.Add("tls.clientauth", "must be none, request, verify or require, got '%s'", t.ClientAuth)
+	} else if (auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert) && conf.CaCert == "" {
+		e.Add("tls.clientauth", "'%s' need cacert", t.ClientAuth)
+	}
+}
+
+func (l LimitConf) validate(e *Errors) {
+	e.positive("limits.rate", l.Rate)
+	e.positive("limits.burst", float64(l.Burst))
+	e.positive("limits.iprate", l.IPRate)
+	e.positive("limits.ipburst", float64(l.IPBurst))
+	e.positive("limits.ipmaxfailures", float64(l.IPMaxFailures))
+	e.positive("limits.failurewindow", float64(l.FailureWindow))
+	e.positive("limits.lockout", float64(l.Lockout))
+}
+
+func (c CORSConf) validate(e *Errors) {
+	for i, o := range c.Origins {
+		if o == "*" {
+			if c.Credentials {
+				e.Add(fmt.Sprintf("cors.origins[%d]", i), "'*' can not be used with credentials")
+			}
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || u.Scheme == "" || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			e.Add(fmt.Sprintf("cors.origins[%d]", i), "'%s' is not an origin like https://host:port", o)
+		}
+	}
+	e.positive("cors.maxage", float64(c.MaxAge))
+}
+
+func (a AuditConf) validate(e *Errors) {
+	for i, s := range a.Sinks {
+		field := fmt.Sprintf("audit.sinks[%d]", i)
+		switch s.Type {
+		case "file", "rotate":
+			if s.MaxSize < 0 || s.MaxFiles < 0 {
+				e.Add(field, "maxsize and maxfiles must be positive")
+			}
+		case "syslog":
+			if s.Network != "tcp" && s.Network != "udp" {
+				e.Add(field, "syslog network must be tcp or udp, got '%s'", s.Network)
+			}
+			if s.Address == "" {
+				e.Add(field, "syslog need an address")
+			}
+		case "http":
+			if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				e.Add(field, "http need an http(s) url, got '%s'", s.URL)
+			}
+		default:
+			e.Add(field, "unknown type '%s', must be file, rotate, syslog or http", s.Type)
+		}
+	}
+}
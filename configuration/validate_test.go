@@ -0,0 +1,51 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	good := Configuration{Listen: "0.0.0.0:5100", PublicCert: "cert/ezb_vault.crt", PrivateKey: "cert/ezb_vault.key", DB: "db/ezb_vault.db", LogLevel: "debug"}
+	if err := good.Validate(); err != nil {
+		t.Errorf("TestValidate was incorrect, got: <%v>, want: <%v>.", err, nil)
+	}
+	bad := good
+	bad.Listen = "0.0.0.0"
+	bad.LogLevel = "verbose"
+	bad.DB = ""
+	bad.TLS.ClientAuth = "require"
+	bad.Limits.Rate = -1
+	bad.Metrics.Allow = []string{"10.0.0.0/8", "lan"}
+	bad.CORS.Origins = []string{"portal.local", "*"}
+	bad.CORS.Credentials = true
+	bad.Audit.Sinks = []AuditSink{{Type: "syslog", Network: "udp"}, {Type: "kafka"}}
+	err := bad.Validate()
+	e, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("TestValidate was incorrect, got: <%v>, want: <Errors>.", err)
+	}
+	want := []string{"listen", "dbpath", "loglevel", "tls.clientauth", "limits.rate", "cors.origins[0]", "cors.origins[1]", "audit.sinks[0]", "audit.sinks[1]", "metrics.allow[1]"}
+	if len(e) != len(want) {
+		t.Fatalf("TestValidate was incorrect, got: <%v>, want fields: <%v>.", err, want)
+	}
+	for i, f := range e {
+		if f.Field != want[i] {
+			t.Errorf("TestValidate field %d was incorrect, got: <%s>, want: <%s>.", i, f.Field, want[i])
+		}
+	}
+}
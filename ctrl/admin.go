@@ -0,0 +1,271 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package ctrl
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/Middleware"
+	"github.com/ezbastion/ezb_vault/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// trail name an administrative action and its target in the audit record.
+func trail(c *gin.Context, action, target string, err error) {
+	c.Set("audit.action", "admin:"+action)
+	c.Set("audit.key", target)
+	if err != nil {
+		c.Set("audit.error", err.Error())
+	}
+}
+
+// shareCounts return the number of shares of each secret id.
+func shareCounts(db *gorm.DB, ids []int) map[int]int {
+	type count struct {
+		KeyValID int
+		Count    int
+	}
+	var counts []count
+	out := make(map[int]int)
+	if len(ids) == 0 {
+		return out
+	}
+	db.Model(&models.Share{}).Select("key_val_id, count(*) as count").Where("key_val_id IN (?)", ids).Group("key_val_id").Scan(&counts)
+	for _, c := range counts {
+		out[c.KeyValID] = c.Count
+	}
+	return out
+}
+
+func GetUsers(c *gin.Context) {
+	var users []models.UserCount
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if err := db.Model(&models.KeyVal{}).Select("u, count(*) as count").Group("u").Scan(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	trail(c, "list-users", "", nil)
+	c.JSON(http.StatusOK, users)
+}
+
+func GetUserKeys(c *gin.Context) {
+	var Raw []models.KeyVal
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	user := c.Param("user")
+	if err := db.Where("u = ?", user).Find(&Raw).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	ids := make([]int, 0, len(Raw))
+	for _, r := range Raw {
+		ids = append(ids, r.ID)
+	}
+	counts := shareCounts(db, ids)
+	out := make([]models.KeyMeta, 0, len(Raw))
+	for _, r := range Raw {
+		out = append(out, r.Meta(counts[r.ID]))
+	}
+	trail(c, "list-keys", user, nil)
+	c.JSON(http.StatusOK, out)
+}
+
+// adminKeyVal load user/name for the admin handlers.
+func adminKeyVal(c *gin.Context, db *gorm.DB) (kv models.KeyVal, err error) {
+	err = db.Where("u = ? AND k = ?", c.Param("user"), c.Param("name")).First(&kv).Error
+	return kv, err
+}
+
+func GetUserKey(c *gin.Context) {
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	kv, kerr := adminKeyVal(c, db)
+	target := c.Param("user") + "/" + c.Param("name")
+	if kerr != nil {
+		trail(c, "read-meta", target, kerr)
+		if gorm.IsRecordNotFoundError(kerr) {
+			c.JSON(http.StatusNotFound, kerr.Error())
+			return
+		}
+		c.JSON(http.StatusInternalServerError, kerr.Error())
+		return
+	}
+	trail(c, "read-meta", target, nil)
+	c.JSON(http.StatusOK, kv.Meta(shareCounts(db, []int{kv.ID})[kv.ID]))
+}
+
+// DeleteUser remove every secret of a departed user, their shares and versions.
+func DeleteUser(c *gin.Context) {
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	user := c.Param("user")
+	tx := db.Begin()
+	ids := tx.Table("key_val").Select("id").Where("u = ?", user).SubQuery()
+	terr := tx.Where("key_val_id IN (?)", ids).Delete(models.Share{}).Error
+	if terr == nil {
+		terr = tx.Where("key_val_id IN (?)", ids).Delete(models.Version{}).Error
+	}
+	if terr == nil {
+		terr = tx.Where("u = ?", user).Delete(models.KeyVal{}).Error
+	}
+	if terr != nil {
+		tx.Rollback()
+		trail(c, "delete-user", user, terr)
+		c.JSON(http.StatusInternalServerError, terr.Error())
+		return
+	}
+	if terr = tx.Commit().Error; terr != nil {
+		trail(c, "delete-user", user, terr)
+		c.JSON(http.StatusInternalServerError, terr.Error())
+		return
+	}
+	trail(c, "delete-user", user, nil)
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// setKeyState apply an admin change to user/name and return its metadata.
+func setKeyState(c *gin.Context, action string, fields map[string]interface{}) {
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	target := c.Param("user") + "/" + c.Param("name")
+	kv, kerr := adminKeyVal(c, db)
+	if kerr == nil {
+		kerr = db.Model(&kv).Updates(fields).Error
+	}
+	trail(c, action, target, kerr)
+	if kerr != nil {
+		if gorm.IsRecordNotFoundError(kerr) {
+			c.JSON(http.StatusNotFound, kerr.Error())
+			return
+		}
+		c.JSON(http.StatusInternalServerError, kerr.Error())
+		return
+	}
+	kv, _ = adminKeyVal(c, db)
+	c.JSON(http.StatusOK, kv.Meta(shareCounts(db, []int{kv.ID})[kv.ID]))
+}
+
+func LockKey(c *gin.Context) {
+	setKeyState(c, "lock", map[string]interface{}{"locked": true})
+}
+
+func UnlockKey(c *gin.Context) {
+	setKeyState(c, "unlock", map[string]interface{}{"locked": false})
+}
+
+// ExpireKey force-expire a secret, now or at the optional ?at= RFC3339 date.
+func ExpireKey(c *gin.Context) {
+	at := time.Now()
+	if q := c.Query("at"); q != "" {
+		t, err := time.Parse(time.RFC3339, q)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+		at = t
+	}
+	setKeyState(c, "expire", map[string]interface{}{"expire_at": at})
+}
+
+func GetRevocations(c *gin.Context) {
+	var revs []models.Revocation
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if err := db.Find(&revs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	trail(c, "list-revocations", "", nil)
+	c.JSON(http.StatusOK, revs)
+}
+
+func AddRevocation(c *gin.Context) {
+	var r models.Revocation
+	rl, _ := c.MustGet("revocations").(*Middleware.RevocationList)
+	if !bindJSON(c, &r) {
+		return
+	}
+	r.ID = 0
+	err := rl.Revoke(&r)
+	trail(c, "revoke", fmt.Sprintf("jti:%s sub:%s", r.JTI, r.SUB), err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, r)
+}
+
+func DeleteRevocation(c *gin.Context) {
+	rl, _ := c.MustGet("revocations").(*Middleware.RevocationList)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	err = rl.Remove(id)
+	trail(c, "unrevoke", c.Param("id"), err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusNoContent, nil)
+}
+
+func GetLockouts(c *gin.Context) {
+	g, ok := c.Get("guard")
+	if !ok {
+		c.JSON(http.StatusOK, []Middleware.Lockout{})
+		return
+	}
+	trail(c, "list-lockouts", "", nil)
+	c.JSON(http.StatusOK, g.(*Middleware.Guard).Lockouts())
+}
+
+func DeleteLockout(c *gin.Context) {
+	key := c.Param("key")
+	g, ok := c.Get("guard")
+	if !ok || !g.(*Middleware.Guard).Unlock(key) {
+		trail(c, "unlock", key, fmt.Errorf("no lockout"))
+		c.JSON(http.StatusNotFound, "no lockout")
+		return
+	}
+	trail(c, "unlock", key, nil)
+	c.JSON(http.StatusNoContent, key)
+}
@@ -0,0 +1,288 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package ctrl
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/ezbastion/ezb_vault/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// HeaderBundleKey is the passphrase sealing an export bundle.
+const HeaderBundleKey = "EZB-VAULT-BUNDLE-KEY"
+
+// bundleKey return the bundle passphrase, or answer 400.
+func bundleKey(c *gin.Context) (string, bool) {
+	k := c.GetHeader(HeaderBundleKey)
+	if len(k) < 8 {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("a bundle passphrase of 8 characters or more is required in %s", HeaderBundleKey))
+		return "", false
+	}
+	return k, true
+}
+
+// source name this vault in the bundles.
+func source(c *gin.Context) string {
+	if live, ok := c.Get("conf"); ok {
+		conf := live.(*configuration.Live).Get()
+		if len(conf.SAN) > 0 {
+			return conf.SAN[0]
+		}
+		return conf.ServiceName
+	}
+	return ""
+}
+
+// Export return the secrets of the owner opened by the key, with their
+// versions, in a bundle sealed with the bundle passphrase. The locked, expired
+// and not opened secrets are only counted.
+func Export(c *gin.Context) {
+	key := c.GetHeader("EZB-VAULT-KEY")
+	bkey, ok := bundleKey(c)
+	if !ok {
+		return
+	}
+	var Raw []models.KeyVal
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	user := Owner(c)
+	if err := db.Where("u = ? ", user).Order("k").Find(&Raw).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	items := []models.BundleItem{}
+	skipped := 0
+	tried := 0
+	for _, r := range Raw {
+		if !Allowed(c, r.K, models.CapRead) || r.Locked || r.Expired() {
+			skipped++
+			continue
+		}
+		tried++
+		o := r.Decrypt(key)
+		if o.V == "" {
+			skipped++
+			continue
+		}
+		it := models.BundleItem{Key: r.K, Value: o.V, Created: r.CreatedAt, Updated: r.UpdatedAt}
+		if dek, derr := r.DataKey(key); derr == nil {
+			versions, verr := models.Versions(db, r.ID)
+			if verr != nil {
+				c.JSON(http.StatusInternalServerError, verr.Error())
+				return
+			}
+			for _, v := range versions {
+				v = v.DecryptWith(dek)
+				it.Versions = append(it.Versions, models.BundleVersion{Version: v.N, Value: v.V, Written: v.Written})
+			}
+		}
+		items = append(items, it)
+	}
+	// like GetAll, only a key opening none of the secrets is a failure.
+	if len(items) == 0 && tried > 0 {
+		decryptFailed(c)
+	}
+	b, serr := models.SealBundle(user, source(c), items, skipped, bkey)
+	if serr != nil {
+		c.JSON(http.StatusInternalServerError, serr.Error())
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+// Import write the secrets of a bundle in the owner namespace, encrypted with
+// the key. ?mode= tell what to do with a key already there: skip (default),
+// overwrite or fail. Nothing is written if a secret is refused, or on a
+// conflict in fail mode, nor with ?dryrun=true, the report tell why. The
+// versions of a created secret are imported, an overwritten one keep its own.
+func Import(c *gin.Context) {
+	key := c.GetHeader("EZB-VAULT-KEY")
+	mode := c.DefaultQuery("mode", models.ImportSkip)
+	if mode != models.ImportSkip && mode != models.ImportOverwrite && mode != models.ImportFail {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("unknown import mode '%s', use skip, overwrite or fail", mode))
+		return
+	}
+	dryRun, perr := strconv.ParseBool(c.DefaultQuery("dryrun", "false"))
+	if perr != nil {
+		c.JSON(http.StatusBadRequest, "dryrun must be true or false")
+		return
+	}
+	bkey, ok := bundleKey(c)
+	if !ok {
+		return
+	}
+	var b models.Bundle
+	if !bindJSON(c, &b) {
+		return
+	}
+	items, oerr := b.Open(bkey)
+	// not a vault key, it does not count toward the lockout
+	if oerr == models.ErrBundleKey {
+		c.JSON(http.StatusForbidden, "#V0025")
+		return
+	}
+	if oerr != nil {
+		c.JSON(http.StatusBadRequest, oerr.Error())
+		return
+	}
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	max := maxValue(c)
+	user := Owner(c)
+	report := models.ImportReport{Mode: mode, DryRun: dryRun, Created: []string{}, Overwritten: []string{}, Skipped: []string{}, Conflicts: []string{}, Errors: map[string]string{}}
+	var writes, replaced []models.KeyVal
+	var histories [][]models.Version
+	failed := false
+	for i, it := range items {
+		switch {
+		case it.Key == "":
+			report.Errors[fmt.Sprintf("#%d", i)] = "empty key"
+			continue
+		case i > 0 && items[i-1].Key == it.Key:
+			report.Errors[it.Key] = "key twice in the bundle"
+			continue
+		case !Allowed(c, it.Key, models.CapWrite):
+			report.Errors[it.Key] = "#V0015"
+			continue
+		case len(it.Value) > max:
+			report.Errors[it.Key] = "#V0024"
+			continue
+		}
+		if msg := checkVersions(it.Versions, max); msg != "" {
+			report.Errors[it.Key] = msg
+			continue
+		}
+		var old models.KeyVal
+		if err := db.Where("u = ? AND k = ?", user, it.Key).First(&old).Error; err != nil {
+			if !gorm.IsRecordNotFoundError(err) {
+				c.JSON(http.StatusInternalServerError, err.Error())
+				return
+			}
+			dek := models.NewDataKey()
+			kv := models.KeyVal{U: user, K: it.Key, V: it.Value, DK: models.WrapKey(dek, key), CreatedAt: it.Created, UpdatedAt: it.Updated}
+			var history []models.Version
+			for _, v := range it.Versions {
+				history = append(history, models.Version{N: v.Version, V: v.Value, Written: v.Written}.EncryptWith(dek))
+			}
+			writes = append(writes, kv.EncryptWith(dek))
+			histories = append(histories, history)
+			report.Created = append(report.Created, it.Key)
+			continue
+		}
+		switch mode {
+		case models.ImportSkip:
+			report.Skipped = append(report.Skipped, it.Key)
+			continue
+		case models.ImportFail:
+			report.Conflicts = append(report.Conflicts, it.Key)
+			continue
+		}
+		if old.Locked {
+			report.Errors[it.Key] = "#V0018"
+			continue
+		}
+		next, prev, ok := replace(old, it.Value, key)
+		if !ok {
+			failed = true
+			report.Errors[it.Key] = "#V0017"
+			continue
+		}
+		writes = append(writes, next)
+		histories = append(histories, nil)
+		replaced = append(replaced, prev)
+		report.Overwritten = append(report.Overwritten, it.Key)
+	}
+	if failed && len(report.Overwritten) == 0 {
+		decryptFailed(c)
+	}
+	status := http.StatusOK
+	switch {
+	case len(report.Errors) > 0:
+		status = http.StatusUnprocessableEntity
+	case mode == models.ImportFail && len(report.Conflicts) > 0:
+		status = http.StatusConflict
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+	if status != http.StatusOK {
+		c.JSON(status, report)
+		return
+	}
+	tx := db.Begin()
+	for _, prev := range replaced {
+		if err := models.AddVersion(tx, prev); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	for i := range writes {
+		var err error
+		if writes[i].ID == 0 {
+			err = tx.Create(&writes[i]).Error
+		} else {
+			err = tx.Save(&writes[i]).Error
+		}
+		for j := 0; err == nil && j < len(histories[i]); j++ {
+			histories[i][j].KeyValID = writes[i].ID
+			err = tx.Create(&histories[i][j]).Error
+		}
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// checkVersions return why the versions of a bundle item are refused, "" if
+// they are not: at most models.MaxVersions, numbered down from the newest, each
+// under the value size.
+func checkVersions(versions []models.BundleVersion, max int) string {
+	if len(versions) > models.MaxVersions {
+		return fmt.Sprintf("more than %d versions", models.MaxVersions)
+	}
+	for i, v := range versions {
+		if v.Version < 1 || (i > 0 && v.Version >= versions[i-1].Version) {
+			return "versions out of order"
+		}
+		if len(v.Value) > max {
+			return "#V0024"
+		}
+	}
+	return ""
+}
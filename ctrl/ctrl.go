@@ -19,6 +19,9 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/ezbastion/ezb_vault/Middleware"
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/ezbastion/ezb_vault/metrics"
 	"github.com/ezbastion/ezb_vault/models"
 
 	"github.com/gin-gonic/gin"
@@ -39,6 +42,87 @@ func Getdbconn(c *gin.Context) (db *gorm.DB, ret string) {
 	return db, ""
 }
 
+// decryptFailed count a secret not opened with the given key, toward the
+// lockout of the subject and its address.
+func decryptFailed(c *gin.Context) {
+	metrics.DecryptFailures.Inc()
+	if g, ok := c.Get("guard"); ok {
+		g.(*Middleware.Guard).Failed(c)
+	}
+}
+
+// bindJSON read the json body, 413 if it is over the limit, 400 if invalid.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		if Middleware.TooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, "#V0023")
+			return false
+		}
+		c.JSON(http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+// maxValue return the configured value size.
+func maxValue(c *gin.Context) int {
+	var conf configuration.Configuration
+	if live, ok := c.Get("conf"); ok {
+		conf = live.(*configuration.Live).Get()
+	}
+	return conf.MaxValueSize()
+}
+
+// valueTooLarge answer 413 if v is over the configured value size.
+func valueTooLarge(c *gin.Context, v string) bool {
+	if len(v) > maxValue(c) {
+		c.JSON(http.StatusRequestEntityTooLarge, "#V0024")
+		return true
+	}
+	return false
+}
+
+// replace seal value in kv with its data key, so the shares stay valid, and
+// return kv updated and the previous value sealed with the same data key for
+// its history. ok is false if key does not open kv. A legacy secret get a data
+// key.
+func replace(kv models.KeyVal, value, key string) (next, prev models.KeyVal, ok bool) {
+	prev = kv
+	dek, err := kv.DataKey(key)
+	switch {
+	case err == models.ErrLegacy:
+		old := kv.Decrypt(key)
+		if old.V == "" {
+			return kv, prev, false
+		}
+		dek = models.NewDataKey()
+		kv.DK = models.WrapKey(dek, key)
+		prev.V = old.EncryptWith(dek).V
+	case err != nil:
+		return kv, prev, false
+	}
+	kv.V = models.KeyVal{V: value}.EncryptWith(dek).V
+	return kv, prev, true
+}
+
+// Owner return the namespace resolved by Middleware.ACL, the token subject by default.
+func Owner(c *gin.Context) string {
+	if o := c.GetString("owner"); o != "" {
+		return o
+	}
+	return c.GetString("sub")
+}
+
+// Allowed evaluate the acl set by Middleware.ACL for a key of the owner namespace.
+func Allowed(c *gin.Context, key, capability string) bool {
+	if acl, ok := c.Get("acl"); ok {
+		if f, ok := acl.(func(string, string) bool); ok {
+			return f(key, capability)
+		}
+	}
+	return Owner(c) == c.GetString("sub")
+}
+
 func GetAll(c *gin.Context) {
 	key := c.GetHeader("EZB-VAULT-KEY")
 	var Raw []models.KeyVal
@@ -48,17 +132,31 @@ func GetAll(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err)
 		return
 	}
-	user, _ := c.MustGet("sub").(string)
+	user := Owner(c)
 	if err := db.Where("u = ? ", user).Find(&Raw).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
+	tried := 0
 	for _, r := range Raw {
+		if !Allowed(c, r.K, models.CapRead) || r.Locked || r.Expired() {
+			continue
+		}
+		tried++
 		o := r.Decrypt(key)
 		if o.V != "" {
+			o.Owner = user
 			out = append(out, o)
 		}
 	}
+	// secrets may be written with different keys, only a key opening none of
+	// them is a failure.
+	if len(out) == 0 && tried > 0 {
+		decryptFailed(c)
+	}
+	if sub := c.GetString("sub"); user == sub {
+		out = append(out, sharedWith(c, db, sub)...)
+	}
 	fmt.Println("out: ", len(out))
 	if len(out) == 0 {
 		c.JSON(http.StatusNoContent, out)
@@ -76,7 +174,7 @@ func GetVal(c *gin.Context) {
 		return
 	}
 	name := c.Param("name")
-	user, _ := c.MustGet("sub").(string)
+	user := Owner(c)
 	if err := db.Where("u = ? AND k = ?", user, name).Find(&Raw).Error; err != nil {
 		if gorm.IsRecordNotFoundError(err) {
 			c.JSON(http.StatusNoContent, err.Error())
@@ -86,15 +184,32 @@ func GetVal(c *gin.Context) {
 			return
 		}
 	}
+	if Raw.Expired() {
+		c.JSON(http.StatusNoContent, "expired")
+		return
+	}
+	if Raw.Locked {
+		c.JSON(http.StatusLocked, "#V0018")
+		return
+	}
 	out := Raw.Decrypt(key)
+	if sub := c.GetString("sub"); user != sub {
+		if kv, sh, err := models.FindShare(db, user, name, sub, c.GetStringSlice("groups")); err == nil {
+			out = decryptShare(c, kv, sh)
+		}
+	}
 	if out.V == "" {
+		decryptFailed(c)
 		c.JSON(http.StatusNoContent, out)
 		return
 	}
+	out.Owner = user
 	c.JSON(http.StatusOK, out)
 }
 
-func AddVal(c *gin.Context) {
+// GetVersions return the values of a secret, the current one first then the
+// previous ones, newest first. Like GetVal, a grantee open them with its share.
+func GetVersions(c *gin.Context) {
 	key := c.GetHeader("EZB-VAULT-KEY")
 	var Raw models.KeyVal
 	db, err := Getdbconn(c)
@@ -102,11 +217,78 @@ func AddVal(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err)
 		return
 	}
-	if err := c.BindJSON(&Raw); err != nil {
+	name := c.Param("name")
+	user := Owner(c)
+	if err := db.Where("u = ? AND k = ?", user, name).First(&Raw).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			c.JSON(http.StatusNoContent, err.Error())
+			return
+		}
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	user, _ := c.MustGet("sub").(string)
+	if Raw.Expired() {
+		c.JSON(http.StatusNoContent, "expired")
+		return
+	}
+	if Raw.Locked {
+		c.JSON(http.StatusLocked, "#V0018")
+		return
+	}
+	dek, derr := Raw.DataKey(key)
+	if sub := c.GetString("sub"); user != sub {
+		if _, sh, err := models.FindShare(db, user, name, sub, c.GetStringSlice("groups")); err == nil {
+			dek, derr = models.UnwrapKey(sh.DK, shareKey(c))
+		}
+	}
+	var cur models.KeyVal
+	switch {
+	case derr == models.ErrLegacy:
+		// a legacy secret was never updated, it has no history
+		cur = Raw.Decrypt(key)
+	case derr == nil:
+		cur = Raw.DecryptWith(dek)
+	}
+	if cur.V == "" {
+		decryptFailed(c)
+		c.JSON(http.StatusNoContent, nil)
+		return
+	}
+	var prev []models.Version
+	if derr == nil {
+		var verr error
+		if prev, verr = models.Versions(db, Raw.ID); verr != nil {
+			c.JSON(http.StatusInternalServerError, verr.Error())
+			return
+		}
+	}
+	out := []models.Version{{N: 1, V: cur.V, Written: Raw.UpdatedAt, Current: true}}
+	if len(prev) > 0 {
+		out[0].N = prev[0].N + 1
+	}
+	for _, v := range prev {
+		out = append(out, v.DecryptWith(dek))
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func AddVal(c *gin.Context) {
+	key := c.GetHeader("EZB-VAULT-KEY")
+	var Raw models.KeyVal
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if !bindJSON(c, &Raw) || valueTooLarge(c, Raw.V) {
+		return
+	}
+	user := Owner(c)
+	c.Set("audit.key", Raw.K)
+	if !Allowed(c, Raw.K, models.CapWrite) {
+		c.JSON(http.StatusForbidden, "#V0015")
+		return
+	}
 	Raw.U = user
 	newRaw := Raw.Encrypt(key)
 	db.NewRecord(Raw)
@@ -125,23 +307,52 @@ func UpdateVal(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err)
 		return
 	}
-	if err := c.BindJSON(&NewRaw); err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+	if !bindJSON(c, &NewRaw) || valueTooLarge(c, NewRaw.V) {
 		return
 	}
-	user, _ := c.MustGet("sub").(string)
+	user := Owner(c)
 	name := c.Param("name")
 	if err := db.Where("u = ? AND k = ?", user, name).Find(&OldRaw).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			c.JSON(http.StatusNotFound, err.Error())
+			return
+		}
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
+	if OldRaw.Locked {
+		c.JSON(http.StatusLocked, "#V0018")
+		return
+	}
 	if NewRaw.K != "" {
+		if !Allowed(c, NewRaw.K, models.CapWrite) {
+			c.JSON(http.StatusForbidden, "#V0015")
+			return
+		}
 		OldRaw.K = NewRaw.K
 	}
+	tx := db.Begin()
 	if NewRaw.V != "" {
-		OldRaw.V = NewRaw.Encrypt(key).V
+		next, prev, ok := replace(OldRaw, NewRaw.V, key)
+		if !ok {
+			tx.Rollback()
+			decryptFailed(c)
+			c.JSON(http.StatusForbidden, "#V0017")
+			return
+		}
+		if err := models.AddVersion(tx, prev); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		OldRaw = next
 	}
-	if err := db.Save(&OldRaw).Error; err != nil {
+	if err := tx.Save(&OldRaw).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -155,8 +366,28 @@ func DeleteVal(c *gin.Context) {
 		return
 	}
 	name := c.Param("name")
-	user, _ := c.MustGet("sub").(string)
-	if err := db.Where("u = ? AND k = ?", user, name).Delete(&Raw).Error; err != nil {
+	user := Owner(c)
+	if err := db.Where("u = ? AND k = ?", user, name).First(&Raw).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			c.JSON(http.StatusNoContent, err.Error())
+			return
+		}
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if Raw.Locked {
+		c.JSON(http.StatusLocked, "#V0018")
+		return
+	}
+	if err := db.Where("key_val_id = ?", Raw.ID).Delete(models.Share{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := db.Where("key_val_id = ?", Raw.ID).Delete(models.Version{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := db.Delete(&Raw).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
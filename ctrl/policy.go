@@ -0,0 +1,84 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package ctrl
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ezbastion/ezb_vault/Middleware"
+	"github.com/ezbastion/ezb_vault/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetPolicies(c *gin.Context) {
+	ps, _ := c.MustGet("policies").(*Middleware.PolicyStore)
+	c.JSON(http.StatusOK, ps.List())
+}
+
+func AddPolicy(c *gin.Context) {
+	var p models.Policy
+	ps, _ := c.MustGet("policies").(*Middleware.PolicyStore)
+	if !bindJSON(c, &p) {
+		return
+	}
+	p.ID = 0
+	err := ps.Save(&p)
+	trail(c, "add-policy", p.Name, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, p)
+}
+
+func UpdatePolicy(c *gin.Context) {
+	var p models.Policy
+	ps, _ := c.MustGet("policies").(*Middleware.PolicyStore)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if !bindJSON(c, &p) {
+		return
+	}
+	p.ID = id
+	err = ps.Save(&p)
+	trail(c, "update-policy", c.Param("id"), err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+func DeletePolicy(c *gin.Context) {
+	ps, _ := c.MustGet("policies").(*Middleware.PolicyStore)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	err = ps.Delete(id)
+	trail(c, "delete-policy", c.Param("id"), err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,41 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package ctrl
+
+import (
+	"net/http"
+
+	"github.com/ezbastion/ezb_vault/configuration"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reload read config.json again, swap the certificate and drop the cached sta
+// keys, without restarting the service.
+func Reload(c *gin.Context) {
+	live, ok := c.MustGet("conf").(*configuration.Live)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, "unknow running configuration")
+		return
+	}
+	err := live.Reload()
+	trail(c, "reload", "", err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, "reloaded")
+}
@@ -0,0 +1,178 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package ctrl
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ezbastion/ezb_vault/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// shareKey return the passphrase of the grantee copies, EZB-VAULT-KEY if not set.
+func shareKey(c *gin.Context) string {
+	if k := c.GetHeader("EZB-VAULT-SHARE-KEY"); k != "" {
+		return k
+	}
+	return c.GetHeader("EZB-VAULT-KEY")
+}
+
+// decryptShare open a shared secret with the grantee copy of its data key.
+func decryptShare(c *gin.Context, kv models.KeyVal, sh models.Share) models.KeyVal {
+	var blank models.KeyVal
+	dek, err := models.UnwrapKey(sh.DK, shareKey(c))
+	if err != nil {
+		return blank
+	}
+	out := kv.DecryptWith(dek)
+	if out.V == "" {
+		return blank
+	}
+	out.Owner = kv.U
+	out.Shared = true
+	return out
+}
+
+// sharedWith return the secrets shared with sub that the share key can open.
+func sharedWith(c *gin.Context, db *gorm.DB, sub string) (out []models.KeyVal) {
+	shares, err := models.SharedWith(db, sub, c.GetStringSlice("groups"))
+	if err != nil {
+		return nil
+	}
+	for _, sh := range shares {
+		var kv models.KeyVal
+		if err := db.Where("id = ?", sh.KeyValID).First(&kv).Error; err != nil || kv.Locked || kv.Expired() {
+			continue
+		}
+		if o := decryptShare(c, kv, sh); o.V != "" {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// ownedKeyVal load a secret of the token subject, shares are managed by the owner only.
+func ownedKeyVal(c *gin.Context, db *gorm.DB) (kv models.KeyVal, status int, msg string) {
+	sub := c.GetString("sub")
+	if Owner(c) != sub {
+		return kv, http.StatusForbidden, "#V0015"
+	}
+	if err := db.Where("u = ? AND k = ?", sub, c.Param("name")).First(&kv).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return kv, http.StatusNotFound, err.Error()
+		}
+		return kv, http.StatusInternalServerError, err.Error()
+	}
+	if kv.Locked {
+		return kv, http.StatusLocked, "#V0018"
+	}
+	return kv, http.StatusOK, ""
+}
+
+func GetShares(c *gin.Context) {
+	var shares []models.Share
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	kv, status, msg := ownedKeyVal(c, db)
+	if msg != "" {
+		c.JSON(status, msg)
+		return
+	}
+	if err := db.Where("key_val_id = ?", kv.ID).Find(&shares).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, shares)
+}
+
+func AddShare(c *gin.Context) {
+	key := c.GetHeader("EZB-VAULT-KEY")
+	var req models.ShareRequest
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	kv, status, msg := ownedKeyVal(c, db)
+	if msg != "" {
+		c.JSON(status, msg)
+		return
+	}
+	dek, derr := kv.DataKey(key)
+	if derr == models.ErrLegacy {
+		// secret written before sharing, move it to a data key first
+		plain := kv.Decrypt(key)
+		if plain.V == "" {
+			decryptFailed(c)
+			c.JSON(http.StatusForbidden, "#V0017")
+			return
+		}
+		dek = models.NewDataKey()
+		plain.DK = models.WrapKey(dek, key)
+		upgraded := plain.EncryptWith(dek)
+		if err := db.Model(&kv).Updates(map[string]interface{}{"v": upgraded.V, "dk": upgraded.DK}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+	} else if derr != nil {
+		decryptFailed(c)
+		c.JSON(http.StatusForbidden, "#V0017")
+		return
+	}
+	sh := models.Share{
+		KeyValID: kv.ID,
+		Grantee:  req.Grantee,
+		IsGroup:  req.IsGroup,
+		DK:       models.WrapKey(dek, req.Passphrase),
+	}
+	if err := db.Create(&sh).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, sh)
+}
+
+func DeleteShare(c *gin.Context) {
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	kv, status, msg := ownedKeyVal(c, db)
+	if msg != "" {
+		c.JSON(status, msg)
+		return
+	}
+	id, cerr := strconv.Atoi(c.Param("id"))
+	if cerr != nil {
+		c.JSON(http.StatusBadRequest, cerr.Error())
+		return
+	}
+	if err := db.Where("id = ? AND key_val_id = ?", id, kv.ID).Delete(models.Share{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,114 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package ctrl
+
+import (
+	"crypto/x509"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/Middleware"
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/ezbastion/ezb_vault/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Server describe the running process to the probe and status handlers.
+// Checks are the readiness checks, a nil error is ready.
+type Server struct {
+	Version string
+	Started time.Time
+	Storage string
+	Checks  map[string]func() error
+	Cert    func() *x509.Certificate
+}
+
+// isAdmin tell if the caller is a vault administrator.
+func isAdmin(c *gin.Context) bool {
+	live, ok := c.Get("conf")
+	if !ok {
+		return false
+	}
+	return Middleware.IsAdmin(live.(*configuration.Live).Get(), c.GetString("sub"), c.GetStringSlice("groups"))
+}
+
+// Health answer as long as the process serve http.
+func (s *Server) Health(c *gin.Context) {
+	c.JSON(http.StatusOK, "ok")
+}
+
+// Ready run the checks, 503 if one of them fail.
+func (s *Server) Ready(c *gin.Context) {
+	names := make([]string, 0, len(s.Checks))
+	for name := range s.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	status := http.StatusOK
+	checks := make(map[string]string)
+	for _, name := range names {
+		checks[name] = "ok"
+		if err := s.Checks[name](); err != nil {
+			checks[name] = err.Error()
+			status = http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(status, gin.H{"ready": status == http.StatusOK, "checks": checks})
+}
+
+// Status return version, uptime, storage, counts and certificate expiry. The
+// counts are of the whole vault for an administrator, of the caller secrets
+// for the others.
+func (s *Server) Status(c *gin.Context) {
+	db, err := Getdbconn(c)
+	if err != "" {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	var st models.Status
+	st.Version = s.Version
+	st.Started = s.Started
+	st.Uptime = time.Since(s.Started).Round(time.Second).String()
+	st.Storage = s.Storage
+	keys := db.Model(&models.KeyVal{})
+	shares := db.Model(&models.Share{})
+	st.Scope = "vault"
+	if !isAdmin(c) {
+		sub := c.GetString("sub")
+		st.Scope = "own"
+		keys = keys.Where("u = ?", sub)
+		shares = shares.Where("key_val_id IN (?)", db.Table("key_val").Select("id").Where("u = ?", sub).SubQuery())
+	} else {
+		keys.Select("count(distinct u)").Row().Scan(&st.Users)
+		db.Model(&models.Policy{}).Count(&st.Policies)
+	}
+	keys.Count(&st.Keys)
+	keys.Where("locked = ?", true).Count(&st.Locked)
+	keys.Where("expire_at IS NOT NULL AND expire_at <= ?", time.Now()).Count(&st.Expired)
+	shares.Count(&st.Shares)
+	if s.Cert != nil {
+		if leaf := s.Cert(); leaf != nil {
+			st.Certificate = &models.CertStatus{
+				Subject:  leaf.Subject.CommonName,
+				NotAfter: leaf.NotAfter,
+				DaysLeft: int(time.Until(leaf.NotAfter).Hours() / 24),
+			}
+		}
+	}
+	c.JSON(http.StatusOK, st)
+}
@@ -0,0 +1,73 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package ctrl
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func probe(s *Server, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", handler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	return w
+}
+
+func TestHealth(t *testing.T) {
+	s := &Server{Checks: map[string]func() error{"db": func() error { return errors.New("down") }}}
+	// the process answer, whatever the checks
+	if w := probe(s, s.Health); w.Code != http.StatusOK {
+		t.Errorf("TestHealth was incorrect, got: <%d>, want: <%d>.", w.Code, http.StatusOK)
+	}
+}
+
+func TestReady(t *testing.T) {
+	ok := func() error { return nil }
+	tests := []struct {
+		checks map[string]func() error
+		want   int
+		failed string
+	}{
+		{map[string]func() error{"db": ok, "audit": ok}, http.StatusOK, ""},
+		{map[string]func() error{"db": ok, "audit": func() error { return errors.New("no sink") }}, http.StatusServiceUnavailable, "audit"},
+		{nil, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		s := &Server{Checks: tt.checks}
+		w := probe(s, s.Ready)
+		var body struct {
+			Ready  bool              `json:"ready"`
+			Checks map[string]string `json:"checks"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != tt.want || body.Ready != (tt.want == http.StatusOK) || len(body.Checks) != len(tt.checks) {
+			t.Errorf("TestReady was incorrect, got: <%d %s>, want: <%d>.", w.Code, w.Body, tt.want)
+		}
+		for name, msg := range body.Checks {
+			if (name == tt.failed) == (msg == "ok") {
+				t.Errorf("TestReady check %s was incorrect, got: <%s>.", name, msg)
+			}
+		}
+	}
+}
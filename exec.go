@@ -0,0 +1,163 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strings"
+
+	"github.com/ezbastion/ezb_vault/client"
+	"github.com/urfave/cli"
+)
+
+var envVar = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envMapping is a --map NAME=key, the secret key set in the NAME variable.
+type envMapping struct {
+	Name string
+	Key  string
+}
+
+func parseMappings(maps []string) ([]envMapping, error) {
+	var out []envMapping
+	seen := map[string]bool{}
+	for _, m := range maps {
+		i := strings.Index(m, "=")
+		if i < 1 || i == len(m)-1 {
+			return nil, fmt.Errorf("invalid --map '%s', want NAME=key", m)
+		}
+		e := envMapping{Name: m[:i], Key: m[i+1:]}
+		if !envVar.MatchString(e.Name) {
+			return nil, fmt.Errorf("invalid variable name '%s'", e.Name)
+		}
+		if seen[e.Name] {
+			return nil, fmt.Errorf("variable %s is mapped twice", e.Name)
+		}
+		seen[e.Name] = true
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// vaultEnv are the vault credentials, never given to a child command.
+var vaultEnv = map[string]bool{
+	"EZB_VAULT_KEY":        true,
+	"EZB_VAULT_SHARE_KEY":  true,
+	"EZB_VAULT_BUNDLE_KEY": true,
+	"EZB_VAULT_TOKEN":      true,
+	"EZB_VAULT_TOKEN_FILE": true,
+}
+
+// childEnv return environ without the vault credentials, with the secrets set
+// (they replace a variable of the same name).
+func childEnv(environ []string, secrets map[string]string) []string {
+	var out []string
+	for _, kv := range environ {
+		name := kv
+		if i := strings.Index(kv, "="); i >= 0 {
+			name = kv[:i]
+		}
+		if _, ok := secrets[name]; ok || vaultEnv[name] {
+			continue
+		}
+		out = append(out, kv)
+	}
+	for name, v := range secrets {
+		out = append(out, name+"="+v)
+	}
+	return out
+}
+
+// runChild start the command, forward it the signals received and return its
+// exit code.
+func runChild(args, env []string) (int, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	sigs := make(chan os.Signal, 4)
+	signal.Notify(sigs, forwardSignals...)
+	defer signal.Stop(sigs)
+	if err := cmd.Start(); err != nil {
+		return 127, err
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				forwardSignal(cmd.Process, sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return 1, err
+	}
+	return exitCode(cmd.ProcessState), nil
+}
+
+// execCommand run a command with secrets in its environment. The values are
+// only given to the child, never written or logged.
+func execCommand() cli.Command {
+	return cli.Command{
+		Name:      "exec",
+		Usage:     "Run a command with secrets in its environment.",
+		ArgsUsage: "--map NAME=key [--map ...] -- <command> [args...]",
+		Flags:     clientFlags(cli.StringSliceFlag{Name: "map, m", Usage: "NAME=key, set the secret key in the NAME variable, repeatable"}),
+		Action: func(c *cli.Context) error {
+			if c.NArg() == 0 {
+				return kvExit(fmt.Errorf("exec need a command, after --"))
+			}
+			maps, err := parseMappings(c.StringSlice("map"))
+			if err != nil {
+				return kvExit(err)
+			}
+			if len(maps) == 0 {
+				return kvExit(fmt.Errorf("exec need at least one --map NAME=key"))
+			}
+			v, err := newVaultClient(c, true)
+			if err != nil {
+				return kvExit(err)
+			}
+			secrets := map[string]string{}
+			for _, m := range maps {
+				var s client.Secret
+				if s, err = v.Get(context.Background(), m.Key); err != nil {
+					return kvExit(fmt.Errorf("%s: %v", m.Name, err))
+				}
+				secrets[m.Name] = s.Value
+			}
+			code, err := runChild(c.Args(), childEnv(os.Environ(), secrets))
+			if err != nil {
+				return cli.NewExitError(err.Error(), code)
+			}
+			if code != 0 {
+				return cli.NewExitError("", code)
+			}
+			return nil
+		},
+	}
+}
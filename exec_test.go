@@ -0,0 +1,57 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os/exec"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseMappings(t *testing.T) {
+	maps, err := parseMappings([]string{"DB_PASS=prod/sql/sa", "TOKEN=api=key"})
+	if err != nil || len(maps) != 2 || maps[0].Key != "prod/sql/sa" || maps[1].Key != "api=key" {
+		t.Errorf("TestParseMappings was incorrect, got: <%v %v>.", maps, err)
+	}
+	for _, bad := range [][]string{{"DB_PASS"}, {"=key"}, {"DB_PASS="}, {"1DB=key"}, {"A-B=key"}, {"A=x", "A=y"}} {
+		if _, err := parseMappings(bad); err == nil {
+			t.Errorf("TestParseMappings %v was incorrect, got: <nil>, want: <error>.", bad)
+		}
+	}
+}
+
+func TestChildEnv(t *testing.T) {
+	env := childEnv([]string{"PATH=/bin", "DB_PASS=old", "EZB_VAULT_KEY=pass", "EZB_VAULT_SHARE_KEY=share", "EZB_VAULT_BUNDLE_KEY=bundle",
+		"EZB_VAULT_TOKEN=eyJ.x.y", "EZB_VAULT_TOKEN_FILE=/run/token", "EZB_VAULT_ADDR=https://vault:5100"}, map[string]string{"DB_PASS": "s3cret"})
+	sort.Strings(env)
+	if got := strings.Join(env, " "); got != "DB_PASS=s3cret EZB_VAULT_ADDR=https://vault:5100 PATH=/bin" {
+		t.Errorf("TestChildEnv was incorrect, got: <%s>, want: <%s>.", got, "DB_PASS=s3cret EZB_VAULT_ADDR=https://vault:5100 PATH=/bin")
+	}
+}
+
+func TestRunChild(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	code, err := runChild([]string{"sh", "-c", `test "$DB_PASS" = s3cret && exit 3`}, []string{"DB_PASS=s3cret"})
+	if err != nil || code != 3 {
+		t.Errorf("TestRunChild was incorrect, got: <%d %v>, want: <%d>.", code, err, 3)
+	}
+	if code, err := runChild([]string{"ezb-no-such-command"}, nil); err == nil || code != 127 {
+		t.Errorf("TestRunChild missing command was incorrect, got: <%d %v>, want: <%d>.", code, err, 127)
+	}
+}
@@ -0,0 +1,39 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// forwardSignals is caught so exec wait the child, the console send Ctrl+C to
+// the child too.
+var forwardSignals = []os.Signal{os.Interrupt}
+
+func forwardSignal(p *os.Process, sig os.Signal) {}
+
+func exitCode(state *os.ProcessState) int {
+	return state.ExitCode()
+}
+
+// shellCommand run line with cmd.
+func shellCommand(line string) *exec.Cmd {
+	return exec.Command("cmd", "/C", line)
+}
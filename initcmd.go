@@ -0,0 +1,87 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/ezbastion/ezb_vault/setup"
+	"github.com/urfave/cli"
+)
+
+// initFlags are the init answers, with one of them nothing is asked.
+func initFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{Name: "answers", Usage: "json answers file, the flags override it"},
+		cli.BoolFlag{Name: "non-interactive, y", Usage: "ask nothing, keep the defaults"},
+		cli.BoolFlag{Name: "offline", Usage: "do not call ezb_pki, use --cert/--key/--ca or the certificates in place"},
+		cli.BoolFlag{Name: "self-signed", Usage: "generate a self-signed certificate, for development only"},
+		cli.StringFlag{Name: "pki", Usage: "ezb_pki address, host:port"},
+		cli.StringFlag{Name: "san", Usage: "comma separated certificate names"},
+		cli.StringFlag{Name: "listen", Usage: "listen address, host:port"},
+		cli.StringFlag{Name: "servicename", Usage: "service name"},
+		cli.StringFlag{Name: "servicefullname", Usage: "service display name"},
+		cli.StringFlag{Name: "loglevel", Usage: "log level"},
+		cli.StringFlag{Name: "dbpath", Usage: "database file"},
+		cli.StringFlag{Name: "cert", Usage: "certificate file to copy"},
+		cli.StringFlag{Name: "key", Usage: "private key file to copy"},
+		cli.StringFlag{Name: "ca", Usage: "CA certificate file to copy"},
+		cli.StringSliceFlag{Name: "sta", Usage: "token issuer public key to copy in the cert folder, <iss>.crt"},
+	}
+}
+
+// initOptions read the answers file then the flags. Any answer make init
+// non-interactive.
+func initOptions(c *cli.Context) (opt setup.Options, err error) {
+	if f := c.String("answers"); f != "" {
+		if opt, err = setup.LoadAnswers(f); err != nil {
+			return opt, err
+		}
+	}
+	for _, a := range []struct {
+		flag string
+		dst  *string
+	}{
+		{"pki", &opt.EzbPki},
+		{"listen", &opt.Listen},
+		{"servicename", &opt.ServiceName},
+		{"servicefullname", &opt.ServiceFullName},
+		{"loglevel", &opt.LogLevel},
+		{"dbpath", &opt.DB},
+		{"cert", &opt.Cert},
+		{"key", &opt.Key},
+		{"ca", &opt.CA},
+	} {
+		if c.IsSet(a.flag) {
+			*a.dst = c.String(a.flag)
+			opt.NonInteractive = true
+		}
+	}
+	if c.IsSet("san") {
+		opt.SAN = strings.Split(strings.Replace(c.String("san"), " ", "", -1), ",")
+		opt.NonInteractive = true
+	}
+	if c.IsSet("sta") {
+		opt.Sta = c.StringSlice("sta")
+		opt.NonInteractive = true
+	}
+	opt.Offline = opt.Offline || c.Bool("offline")
+	opt.SelfSigned = opt.SelfSigned || c.Bool("self-signed")
+	if c.Bool("non-interactive") {
+		opt.NonInteractive = true
+	}
+	return opt, nil
+}
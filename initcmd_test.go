@@ -0,0 +1,84 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ezbastion/ezb_vault/setup"
+	"github.com/urfave/cli"
+)
+
+// runInit parse the init flags of args and return the answers.
+func runInit(t *testing.T, args ...string) (opt setup.Options, err error) {
+	app := cli.NewApp()
+	app.Flags = initFlags()
+	app.Action = func(c *cli.Context) error {
+		opt, err = initOptions(c)
+		return nil
+	}
+	if rerr := app.Run(append([]string{"ezb_vault"}, args...)); rerr != nil {
+		t.Fatal(rerr)
+	}
+	return opt, err
+}
+
+// A flag override the answers file, the other answers are kept.
+func TestInitOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	answers := filepath.Join(dir, "answers.json")
+	ioutil.WriteFile(answers, []byte(`{"offline":true,"ezb_pki":"pki.local:5010","san":["a.local"],"listen":"0.0.0.0:5100","loglevel":"info","sta":["a.crt"]}`), 0600)
+
+	opt, err := runInit(t, "--answers", answers, "--listen", "127.0.0.1:5200", "--san", "b.local, c.local", "--sta", "b.crt")
+	if err != nil {
+		t.Fatalf("TestInitOptions was incorrect, got: <%v>, want: <%v>.", err, nil)
+	}
+	if opt.Listen != "127.0.0.1:5200" || len(opt.SAN) != 2 || opt.SAN[1] != "c.local" || len(opt.Sta) != 1 || opt.Sta[0] != "b.crt" {
+		t.Errorf("TestInitOptions flags were incorrect, got: <%+v>.", opt)
+	}
+	if !opt.Offline || opt.EzbPki != "pki.local:5010" || opt.LogLevel != "info" || !opt.NonInteractive {
+		t.Errorf("TestInitOptions answers were incorrect, got: <%+v>.", opt)
+	}
+
+	// --offline and --self-signed add to the answers, they do not clear them
+	opt, _ = runInit(t, "--answers", answers, "--self-signed")
+	if !opt.Offline || !opt.SelfSigned {
+		t.Errorf("TestInitOptions modes were incorrect, got: <offline %t self-signed %t>, want: <true true>.", opt.Offline, opt.SelfSigned)
+	}
+
+	if opt, _ = runInit(t); opt.NonInteractive {
+		t.Errorf("TestInitOptions none was incorrect, got: <%t>, want: <%t>.", opt.NonInteractive, false)
+	}
+	for _, args := range [][]string{{"-y"}, {"--dbpath", "db/test.db"}} {
+		if opt, _ = runInit(t, args...); !opt.NonInteractive {
+			t.Errorf("TestInitOptions %v was incorrect, got: <%t>, want: <%t>.", args, opt.NonInteractive, true)
+		}
+	}
+
+	if _, err = runInit(t, "--answers", filepath.Join(dir, "missing.json"), "--listen", "127.0.0.1:5200"); err == nil {
+		t.Fatalf("TestInitOptions missing was incorrect, got: <%v>, want an error.", err)
+	}
+	if e, ok := err.(*setup.Error); !ok || e.Code != setup.ExitAnswers {
+		t.Errorf("TestInitOptions missing was incorrect, got: <%v>, want exit code: <%d>.", err, setup.ExitAnswers)
+	}
+}
@@ -0,0 +1,411 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/client"
+	"github.com/urfave/cli"
+	"golang.org/x/term"
+)
+
+// kvFlags are the connection flags of the kv subcommands.
+var kvFlags = []cli.Flag{
+	cli.StringFlag{Name: "addr", EnvVar: "EZB_VAULT_ADDR", Usage: "vault url, https://host:port"},
+	cli.StringFlag{Name: "token", EnvVar: "EZB_VAULT_TOKEN", Usage: "jwt from ezb_sta"},
+	cli.StringFlag{Name: "token-file", EnvVar: "EZB_VAULT_TOKEN_FILE", Usage: "file holding the jwt"},
+	cli.StringFlag{Name: "ca", EnvVar: "EZB_VAULT_CA", Usage: "CA certificate of the vault, the system ones by default"},
+	cli.StringFlag{Name: "owner", Usage: "owner of a secret shared with you"},
+	cli.StringFlag{Name: "format, o", Value: "text", Usage: "text, json or export"},
+}
+
+// clientFlags are the connection flags of kvFlags, with extra.
+func clientFlags(extra ...cli.Flag) []cli.Flag {
+	for _, f := range kvFlags {
+		if f.GetName() != "format, o" {
+			extra = append(extra, f)
+		}
+	}
+	return extra
+}
+
+// newVaultClient return a client of the vault set by the flags. The
+// passphrase is read from EZB_VAULT_KEY or asked, never from the command line.
+func newVaultClient(c *cli.Context, needKey bool) (*client.Client, error) {
+	if c.String("addr") == "" {
+		return nil, fmt.Errorf("vault address is required, --addr or EZB_VAULT_ADDR")
+	}
+	conf := client.Config{Addr: c.String("addr"), CACert: c.String("ca")}
+	switch {
+	case c.String("token") != "":
+		conf.Token = client.StaticToken(c.String("token"))
+	case c.String("token-file") != "":
+		conf.Token = client.FileToken(c.String("token-file"))
+	default:
+		return nil, fmt.Errorf("token is required, --token, --token-file, EZB_VAULT_TOKEN or EZB_VAULT_TOKEN_FILE")
+	}
+	if needKey {
+		var err error
+		if conf.Key, err = passphrase("EZB_VAULT_KEY", "vault key: "); err != nil {
+			return nil, err
+		}
+		conf.ShareKey = os.Getenv("EZB_VAULT_SHARE_KEY")
+	}
+	v, err := client.New(conf)
+	if err != nil {
+		return nil, err
+	}
+	if owner := c.String("owner"); owner != "" {
+		v = v.WithOwner(owner)
+	}
+	return v, nil
+}
+
+// passphrase read env, or ask it on the terminal without echo.
+func passphrase(env, prompt string) (string, error) {
+	if p := os.Getenv(env); p != "" {
+		return p, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("%s is not set and there is no terminal to ask it", env)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(p), err
+}
+
+var envName = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// exportLine return a shell export of the secret, the key is upper-cased and
+// other characters than letters, digits and _ are replaced by _.
+func exportLine(s client.Secret) string {
+	name := envName.ReplaceAllString(strings.ToUpper(s.Key), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return fmt.Sprintf("export %s='%s'", name, strings.Replace(s.Value, "'", `'\''`, -1))
+}
+
+// printSecrets write the secrets in the --format asked.
+func printSecrets(c *cli.Context, secrets []client.Secret, single bool) error {
+	switch c.String("format") {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if single {
+			return enc.Encode(secrets[0])
+		}
+		return enc.Encode(secrets)
+	case "export":
+		for _, s := range secrets {
+			fmt.Println(exportLine(s))
+		}
+	case "text", "":
+		for _, s := range secrets {
+			switch {
+			case single:
+				fmt.Println(s.Value)
+			case s.Shared:
+				fmt.Printf("%s\t(shared by %s)\n", s.Key, s.Owner)
+			default:
+				fmt.Println(s.Key)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown format '%s', use text, json or export", c.String("format"))
+	}
+	return nil
+}
+
+// printReport write an import report in the --format asked.
+func printReport(c *cli.Context, r client.ImportReport) error {
+	switch c.String("format") {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case "text", "":
+		refused := len(r.Errors) > 0 || (r.Mode == client.ImportFail && len(r.Conflicts) > 0)
+		verb := ""
+		if r.DryRun || refused {
+			verb = "would be "
+		}
+		for _, l := range []struct {
+			what string
+			keys []string
+		}{{"created", r.Created}, {"overwritten", r.Overwritten}, {"skipped", r.Skipped}, {"in conflict", r.Conflicts}} {
+			for _, k := range l.keys {
+				fmt.Printf("%s\t%s%s\n", k, verb, l.what)
+			}
+		}
+		keys := make([]string, 0, len(r.Errors))
+		for k := range r.Errors {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s\trefused: %s\n", k, r.Errors[k])
+		}
+		switch {
+		case refused && r.DryRun:
+			fmt.Fprintln(os.Stderr, "the import would be refused, nothing would be written")
+		case refused:
+			fmt.Fprintln(os.Stderr, "import refused, nothing written")
+		}
+	default:
+		return fmt.Errorf("unknown format '%s', use text or json", c.String("format"))
+	}
+	return nil
+}
+
+// printVersions write the versions of a secret in the --format asked.
+func printVersions(c *cli.Context, versions []client.Version) error {
+	switch c.String("format") {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(versions)
+	case "text", "":
+		for _, v := range versions {
+			current := ""
+			if v.Current {
+				current = " (current)"
+			}
+			fmt.Printf("%d%s\t%s\t%s\n", v.Version, current, v.Written.Format(time.RFC3339), v.Value)
+		}
+	default:
+		return fmt.Errorf("unknown format '%s', use text or json", c.String("format"))
+	}
+	return nil
+}
+
+// readValue return the value argument, or read it from stdin (asked without
+// echo on a terminal).
+func readValue(c *cli.Context) (string, error) {
+	if c.NArg() > 1 {
+		return c.Args().Get(1), nil
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "value: ")
+		v, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(v), err
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(os.Stdin, 16<<20))
+	return strings.TrimSuffix(strings.TrimSuffix(string(raw), "\n"), "\r"), err
+}
+
+func kvExit(err error) error {
+	if err == nil {
+		return nil
+	}
+	return cli.NewExitError(err.Error(), 1)
+}
+
+// kvCommand read and write secrets of a remote vault.
+func kvCommand() cli.Command {
+	return cli.Command{
+		Name:  "kv",
+		Usage: "Read and write secrets of a vault.",
+		Subcommands: []cli.Command{
+			{
+				Name:      "get",
+				Usage:     "Print a secret.",
+				ArgsUsage: "<key>",
+				Flags:     kvFlags,
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return kvExit(fmt.Errorf("get need a key"))
+					}
+					v, err := newVaultClient(c, true)
+					if err != nil {
+						return kvExit(err)
+					}
+					s, err := v.Get(context.Background(), c.Args().First())
+					if err != nil {
+						return kvExit(err)
+					}
+					return kvExit(printSecrets(c, []client.Secret{s}, true))
+				},
+			}, {
+				Name:  "list",
+				Usage: "List the secrets the key open, yours and the ones shared with you.",
+				Flags: kvFlags,
+				Action: func(c *cli.Context) error {
+					v, err := newVaultClient(c, true)
+					if err != nil {
+						return kvExit(err)
+					}
+					secrets, err := v.List(context.Background())
+					if err != nil {
+						return kvExit(err)
+					}
+					sort.Slice(secrets, func(i, j int) bool { return secrets[i].Key < secrets[j].Key })
+					return kvExit(printSecrets(c, secrets, false))
+				},
+			}, {
+				Name:      "put",
+				Usage:     "Create or update a secret, the value is read from stdin if not given.",
+				ArgsUsage: "<key> [value]",
+				Flags:     kvFlags,
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 1 || c.NArg() > 2 {
+						return kvExit(fmt.Errorf("put need a key and a value"))
+					}
+					v, err := newVaultClient(c, true)
+					if err != nil {
+						return kvExit(err)
+					}
+					value, err := readValue(c)
+					if err != nil {
+						return kvExit(err)
+					}
+					name := c.Args().First()
+					if err := v.Set(context.Background(), name, value); err != nil {
+						return kvExit(err)
+					}
+					fmt.Fprintf(os.Stderr, "%s saved\n", name)
+					return nil
+				},
+			}, {
+				Name:      "delete",
+				Usage:     "Delete a secret.",
+				ArgsUsage: "<key>",
+				Flags:     kvFlags,
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return kvExit(fmt.Errorf("delete need a key"))
+					}
+					v, err := newVaultClient(c, false)
+					if err != nil {
+						return kvExit(err)
+					}
+					if err := v.Delete(context.Background(), c.Args().First()); err != nil {
+						return kvExit(err)
+					}
+					fmt.Fprintf(os.Stderr, "%s deleted\n", c.Args().First())
+					return nil
+				},
+			}, {
+				Name:      "versions",
+				Usage:     "Print the values of a secret, the current one first.",
+				ArgsUsage: "<key>",
+				Flags:     kvFlags,
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return kvExit(fmt.Errorf("versions need a key"))
+					}
+					v, err := newVaultClient(c, true)
+					if err != nil {
+						return kvExit(err)
+					}
+					versions, err := v.Versions(context.Background(), c.Args().First())
+					if err != nil {
+						return kvExit(err)
+					}
+					return kvExit(printVersions(c, versions))
+				},
+			}, {
+				Name:  "export",
+				Usage: "Export the secrets the key open in a bundle sealed with EZB_VAULT_BUNDLE_KEY.",
+				Flags: append([]cli.Flag{cli.StringFlag{Name: "file, f", Usage: "bundle file, stdout by default"}}, kvFlags...),
+				Action: func(c *cli.Context) error {
+					v, err := newVaultClient(c, true)
+					if err != nil {
+						return kvExit(err)
+					}
+					bkey, err := passphrase("EZB_VAULT_BUNDLE_KEY", "bundle passphrase: ")
+					if err != nil {
+						return kvExit(err)
+					}
+					bundle, err := v.Export(context.Background(), bkey)
+					if err != nil {
+						return kvExit(err)
+					}
+					var meta struct{ Count, Skipped int }
+					json.Unmarshal(bundle, &meta)
+					if f := c.String("file"); f != "" {
+						err = ioutil.WriteFile(f, bundle, 0600)
+					} else {
+						_, err = os.Stdout.Write(append(bundle, '\n'))
+					}
+					if err != nil {
+						return kvExit(err)
+					}
+					fmt.Fprintf(os.Stderr, "%d secret(s) exported", meta.Count)
+					if meta.Skipped > 0 {
+						fmt.Fprintf(os.Stderr, ", %d locked, expired or not opened by the key skipped", meta.Skipped)
+					}
+					fmt.Fprintln(os.Stderr)
+					return nil
+				},
+			}, {
+				Name:      "import",
+				Usage:     "Import a bundle, opened with EZB_VAULT_BUNDLE_KEY.",
+				ArgsUsage: "<file|->",
+				Flags: append([]cli.Flag{
+					cli.StringFlag{Name: "mode", Value: client.ImportSkip, Usage: "for a key already there: skip, overwrite or fail"},
+					cli.BoolFlag{Name: "dry-run", Usage: "only report what the import would do"},
+				}, kvFlags...),
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return kvExit(fmt.Errorf("import need a bundle file, - for stdin"))
+					}
+					var bundle []byte
+					var err error
+					if f := c.Args().First(); f == "-" {
+						bundle, err = ioutil.ReadAll(os.Stdin)
+					} else {
+						bundle, err = ioutil.ReadFile(f)
+					}
+					if err != nil {
+						return kvExit(err)
+					}
+					v, err := newVaultClient(c, true)
+					if err != nil {
+						return kvExit(err)
+					}
+					bkey, err := passphrase("EZB_VAULT_BUNDLE_KEY", "bundle passphrase: ")
+					if err != nil {
+						return kvExit(err)
+					}
+					report, err := v.Import(context.Background(), bundle, bkey, c.String("mode"), c.Bool("dry-run"))
+					if report.Mode == "" {
+						return kvExit(err)
+					}
+					if perr := printReport(c, report); perr != nil {
+						return kvExit(perr)
+					}
+					if err != nil || len(report.Errors) > 0 || (report.Mode == client.ImportFail && len(report.Conflicts) > 0) {
+						return cli.NewExitError("", 1)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,93 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ezbastion/ezb_lib/logmanager"
+)
+
+// lifecycle run the background jobs of the server, they are all cancelled and
+// waited for by Stop, before the resources they use are closed.
+type lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	closes []closer
+}
+
+// closer is a resource closed by Stop.
+type closer struct {
+	name  string
+	close func() error
+}
+
+func newLifecycle(parent context.Context) *lifecycle {
+	ctx, cancel := context.WithCancel(parent)
+	return &lifecycle{ctx: ctx, cancel: cancel}
+}
+
+// Go start fn, it must return when ctx is done. A panic is logged, not fatal.
+func (l *lifecycle) Go(name string, fn func(ctx context.Context)) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				logmanager.Error(fmt.Sprintf("background job %s crashed: %v", name, r))
+			}
+		}()
+		fn(l.ctx)
+	}()
+}
+
+// Every run fn each d until Stop.
+func (l *lifecycle) Every(name string, d time.Duration, fn func()) {
+	l.Go(name, func(ctx context.Context) {
+		ti := time.NewTicker(d)
+		defer ti.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ti.C:
+				fn()
+			}
+		}
+	})
+}
+
+// Defer register a resource closed by Stop once the jobs are done, the last
+// registered first like defer. An error is logged.
+func (l *lifecycle) Defer(name string, close func() error) {
+	l.closes = append(l.closes, closer{name: name, close: close})
+}
+
+// Stop cancel the jobs, wait for them and close the resources.
+func (l *lifecycle) Stop() {
+	l.cancel()
+	l.wg.Wait()
+	for i := len(l.closes) - 1; i >= 0; i-- {
+		if err := l.closes[i].close(); err != nil {
+			logmanager.Error(fmt.Sprintf("Error during closing %s : %s", l.closes[i].name, err.Error()))
+		}
+	}
+	l.closes = nil
+}
@@ -0,0 +1,93 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLifecycleStop(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	done := func(what string) {
+		mu.Lock()
+		order = append(order, what)
+		mu.Unlock()
+	}
+	jobs := newLifecycle(context.Background())
+	jobs.Defer("database", func() error { done("database"); return nil })
+	jobs.Defer("audit log", func() error { done("audit"); return nil })
+	started := make(chan struct{})
+	jobs.Go("wait", func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		// a job still running hold the resources
+		time.Sleep(10 * time.Millisecond)
+		done("jobs")
+	})
+	var ticks int32
+	jobs.Every("tick", time.Millisecond, func() { atomic.AddInt32(&ticks, 1) })
+	jobs.Go("crash", func(context.Context) { panic("boom") })
+	<-started
+	time.Sleep(5 * time.Millisecond)
+	jobs.Stop()
+	if got := strings.Join(order, " "); got != "jobs audit database" {
+		t.Errorf("TestLifecycleStop was incorrect, got: <%s>, want: <%s>.", got, "jobs audit database")
+	}
+	if jobs.ctx.Err() == nil {
+		t.Errorf("TestLifecycleStop was incorrect, the context of the jobs is not cancelled.")
+	}
+	n := atomic.LoadInt32(&ticks)
+	time.Sleep(5 * time.Millisecond)
+	if atomic.LoadInt32(&ticks) != n {
+		t.Errorf("TestLifecycleStop was incorrect, the ticker run after Stop.")
+	}
+}
+
+// a startup error is returned, and what was opened before is closed.
+func TestServeStartupError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "conf"), 0700)
+	os.MkdirAll(filepath.Join(dir, "db"), 0700)
+	tests := []struct {
+		conf string
+		want string
+	}{
+		{`{"listen":"127.0.0.1"}`, "Invalid configuration"},
+		{`{"listen":"127.0.0.1:5199","dbpath":"db/test.db","publiccert":"cert/missing.crt","privatekey":"cert/missing.key"}`, "Error during loading certificate"},
+	}
+	for _, tt := range tests {
+		ioutil.WriteFile(filepath.Join(dir, "conf", "config.json"), []byte(tt.conf), 0600)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := serve(ctx, dir, nil)
+		cancel()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("TestServeStartupError was incorrect, got: <%v>, want: <%s>.", err, tt.want)
+		}
+	}
+}
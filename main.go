@@ -19,16 +19,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/ezbastion/ezb_lib/logmanager"
-	"github.com/ezbastion/ezb_lib/servicemanager"
 	"github.com/ezbastion/ezb_vault/configuration"
 	"github.com/ezbastion/ezb_vault/setup"
 
 	"github.com/urfave/cli"
-	"golang.org/x/sys/windows/svc"
 )
 
+// version is reported by the cli and /sys/status.
+const version = "0.1.1-rc2"
+
 var logPath string
 var exPath string
 var conf configuration.Configuration
@@ -39,16 +41,24 @@ func init() {
 
 	ex, _ := os.Executable()
 	exPath = filepath.Dir(ex)
-	// Loading the conf if exists
-
-	conf, err = configuration.CheckConfig(true, exPath)
+	startEventLog("ezb_vault")
+	isIntSess = isInteractive()
+	// Loading the conf if exists, the flags are read again by the cli
+	loadConf()
 
-	if err != nil {
-		firstcall = true
+	if !isIntSess {
+		// if not in session, set a default log folder
+		logmanager.Info("EZB_VAULT started by system command")
 	}
+}
+
+// loadConf read the configuration from the sources given to
+// configuration.SetSource, and set the log folder and level.
+func loadConf() error {
+	conf, err = configuration.CheckConfig(true, exPath)
+	firstcall = err != nil
 
 	defaultconflisten = conf.Listen
-	logmanager.StartWindowsEvent("ezb_vault")
 
 	exe, _ := os.Executable()
 	// logpath is not the same with a debug (exe folder) or service (%windor%\system32)
@@ -57,15 +67,38 @@ func init() {
 	} else {
 		logPath = conf.LogPath
 	}
-	isIntSess, _ = svc.IsAnInteractiveSession()
 	if !firstcall {
-		logmanager.SetLogLevel(conf.LogLevel, logPath, "ezb_vault.log", 1024, 5, 10, isIntSess, conf.ReportCaller, conf.JsonToStdout)
+		setLogLevel(conf)
 	}
+	return err
+}
 
-	if !isIntSess {
-		// if not in session, set a default log folder
-		logmanager.Info("EZB_VAULT started by system command")
+// confFlags return --config and a flag for each configuration field,
+// "tls.minversion" is --tls-minversion.
+func confFlags() []cli.Flag {
+	flags := []cli.Flag{
+		cli.StringFlag{Name: "config", Usage: "config file (default conf/config.json next to the executable)", EnvVar: configuration.EnvPrefix + "CONFIG"},
+	}
+	for _, f := range configuration.Fields() {
+		flags = append(flags, cli.StringFlag{Name: strings.Replace(f, ".", "-", -1), Usage: "override " + f + ", or " + configuration.EnvName(f), Hidden: true})
 	}
+	return flags
+}
+
+// confOverrides return the configuration flags set on the command line.
+func confOverrides(c *cli.Context) map[string]string {
+	out := make(map[string]string)
+	for _, f := range configuration.Fields() {
+		if name := strings.Replace(f, ".", "-", -1); c.GlobalIsSet(name) {
+			out[f] = c.GlobalString(name)
+		}
+	}
+	return out
+}
+
+// setLogLevel apply the log settings of c, also on reload.
+func setLogLevel(c configuration.Configuration) {
+	logmanager.SetLogLevel(c.LogLevel, logPath, "ezb_vault.log", 1024, 5, 10, isIntSess, c.ReportCaller, c.JsonToStdout)
 }
 
 func main() {
@@ -83,15 +116,33 @@ func main() {
 	// from here, we are in session, handle the commands
 	app := cli.NewApp()
 	app.Name = "ezb_vault"
-	app.Version = "0.1.1-rc2"
+	app.Version = version
 	app.Usage = "Manage ezBastion key/value vault storage."
+	app.Flags = confFlags()
+	app.Before = func(c *cli.Context) error {
+		if c.GlobalString("config") == "" && len(confOverrides(c)) == 0 {
+			return nil
+		}
+		configuration.SetSource(c.GlobalString("config"), confOverrides(c))
+		if err := loadConf(); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
 
 	app.Commands = []cli.Command{
 		{
 			Name:  "init",
 			Usage: "Genarate config file.",
+			Flags: initFlags(),
 			Action: func(c *cli.Context) error {
-				err := setup.Setup(true, firstcall)
+				opt, err := initOptions(c)
+				if err == nil {
+					err = setup.SetupWith(opt, firstcall)
+				}
+				if e, ok := err.(*setup.Error); ok {
+					return cli.NewExitError(e.Error(), e.Code)
+				}
 				return err
 			},
 		}, {
@@ -105,47 +156,76 @@ func main() {
 				RunService(conf.ServiceName, true)
 				return nil
 			},
+		}, {
+			Name:  "run",
+			Usage: "Start ezb_vault in foreground, for containers and systemd.",
+			Action: func(c *cli.Context) error {
+				logmanager.Debug("cli command run started")
+				if firstcall {
+					logmanager.Fatal(fmt.Sprintf("%v not initialized", app.Name))
+				}
+				RunForeground(conf.ServiceName)
+				return nil
+			},
+		}, {
+			Name:  "reload",
+			Usage: "Reload config.json and certificates of the running service.",
+			Action: func(c *cli.Context) error {
+				logmanager.Debug("cli command reload started")
+				if firstcall {
+					logmanager.Fatal(fmt.Sprintf("%v not initialized", app.Name))
+				}
+				return reloadService(conf.ServiceName)
+			},
 		}, {
 			Name:  "install",
-			Usage: "Add ezb_vault deamon windows service.",
+			Usage: "Add ezb_vault deamon service (windows service or systemd unit).",
 			Action: func(c *cli.Context) error {
 				logmanager.Debug("cli command install started")
 				if firstcall {
 					logmanager.Fatal(fmt.Sprintf("%v not initialized", app.Name))
 				}
-				return servicemanager.InstallService(conf.ServiceName, conf.ServiceFullName)
+				return installService(conf.ServiceName, conf.ServiceFullName)
 			},
 		}, {
 			Name:  "remove",
-			Usage: "Remove ezb_vault deamon windows service.",
+			Usage: "Remove ezb_vault deamon service (windows service or systemd unit).",
 			Action: func(c *cli.Context) error {
 				logmanager.Debug("cli command remove started")
 				if firstcall {
 					logmanager.Fatal(fmt.Sprintf("%v not initialized", app.Name))
 				}
-				return servicemanager.RemoveService(conf.ServiceName)
+				return removeService(conf.ServiceName)
 			},
 		}, {
 			Name:  "start",
-			Usage: "Start ezb_vault deamon windows service.",
+			Usage: "Start ezb_vault deamon service (windows service or systemd unit).",
 			Action: func(c *cli.Context) error {
 				logmanager.Debug("cli command start started")
 				if firstcall {
 					logmanager.Fatal(fmt.Sprintf("%v not initialized", app.Name))
 				}
-				return servicemanager.StartService(conf.ServiceName)
+				return startService(conf.ServiceName)
 			},
 		}, {
 			Name:  "stop",
-			Usage: "Stop ezb_vault deamon windows service.",
+			Usage: "Stop ezb_vault deamon service (windows service or systemd unit).",
 			Action: func(c *cli.Context) error {
 				logmanager.Debug("cli command stop started")
 				if firstcall {
 					logmanager.Fatal(fmt.Sprintf("%v not initialized", app.Name))
 				}
-				return servicemanager.ControlService(conf.ServiceName, svc.Stop, svc.Stopped)
+				return stopService(conf.ServiceName)
 			},
 		},
+		revokeCommand(),
+		policyCommand(),
+		auditCommand(),
+		configCommand(),
+		kvCommand(),
+		execCommand(),
+		agentCommand(),
+		proxyCommand(),
 	}
 
 	cli.AppHelpTemplate = fmt.Sprintf(`
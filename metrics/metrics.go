@@ -0,0 +1,146 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"crypto/x509"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/models"
+	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ezb_vault_http_requests_total",
+		Help: "HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+	Duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ezb_vault_http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	Rejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ezb_vault_auth_rejections_total",
+		Help: "Requests rejected by the token, policy or admin checks, by #V code.",
+	}, []string{"code"})
+	DecryptFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ezb_vault_decrypt_failures_total",
+		Help: "Secrets not decrypted, wrong EZB-VAULT-KEY or share key.",
+	})
+	DBDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ezb_vault_db_query_duration_seconds",
+		Help:    "Database query latency by operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+)
+
+// Registry hold the vault metrics, with the go and process ones.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(Requests, Duration, Rejections, DecryptFailures, DBDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Handler serve the registry in the prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// the gauges are registered once, a later MainGin only swap the database
+// and the certificate they read.
+var (
+	mu         sync.Mutex
+	secretsDB  *gorm.DB
+	certLeaf   func() *x509.Certificate
+	secretsReg sync.Once
+	certReg    sync.Once
+)
+
+// Secrets export the secret count of the backend, read at each scrape. The
+// backend label is the one of the first call.
+func Secrets(db *gorm.DB, backend string) {
+	mu.Lock()
+	secretsDB = db
+	mu.Unlock()
+	secretsReg.Do(func() {
+		Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "ezb_vault_secrets",
+			Help:        "Secrets stored, by backend.",
+			ConstLabels: prometheus.Labels{"backend": backend},
+		}, func() float64 {
+			mu.Lock()
+			db := secretsDB
+			mu.Unlock()
+			var n int
+			db.Model(&models.KeyVal{}).Count(&n)
+			return float64(n)
+		}))
+	})
+}
+
+// CertExpiry export the days left before the served certificate expire.
+func CertExpiry(leaf func() *x509.Certificate) {
+	mu.Lock()
+	certLeaf = leaf
+	mu.Unlock()
+	certReg.Do(func() {
+		Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "ezb_vault_certificate_expiry_days",
+			Help: "Days before the server certificate expire.",
+		}, func() float64 {
+			mu.Lock()
+			leaf := certLeaf
+			mu.Unlock()
+			if l := leaf(); l != nil {
+				return time.Until(l.NotAfter).Hours() / 24
+			}
+			return 0
+		}))
+	})
+}
+
+// InstrumentDB time every gorm operation.
+func InstrumentDB(db *gorm.DB) {
+	start := func(scope *gorm.Scope) {
+		scope.InstanceSet("metrics:start", time.Now())
+	}
+	observe := func(op string) func(scope *gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			if t, ok := scope.InstanceGet("metrics:start"); ok {
+				DBDuration.WithLabelValues(op).Observe(time.Since(t.(time.Time)).Seconds())
+			}
+		}
+	}
+	cb := db.Callback()
+	cb.Create().Before("gorm:create").Register("metrics:start_create", start)
+	cb.Create().After("gorm:create").Register("metrics:create", observe("create"))
+	cb.Query().Before("gorm:query").Register("metrics:start_query", start)
+	cb.Query().After("gorm:query").Register("metrics:query", observe("query"))
+	cb.Update().Before("gorm:update").Register("metrics:start_update", start)
+	cb.Update().After("gorm:update").Register("metrics:update", observe("update"))
+	cb.Delete().Before("gorm:delete").Register("metrics:start_delete", start)
+	cb.Delete().After("gorm:delete").Register("metrics:delete", observe("delete"))
+	cb.RowQuery().Before("gorm:row_query").Register("metrics:start_row_query", start)
+	cb.RowQuery().After("gorm:row_query").Register("metrics:row_query", observe("row_query"))
+}
@@ -0,0 +1,78 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/models"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+func testDB(t *testing.T, n int) *gorm.DB {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	db.AutoMigrate(&models.KeyVal{})
+	for i := 0; i < n; i++ {
+		db.Create(&models.KeyVal{U: "me", K: string(rune('a' + i)), V: "x"})
+	}
+	return db
+}
+
+func gauge(t *testing.T, name string) float64 {
+	mfs, err := Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("gauge %s not registered", name)
+	return 0
+}
+
+// MainGin run again, after a failed start or in the tests, must not panic and
+// must read the new database and certificate.
+func TestRegisterTwice(t *testing.T) {
+	Secrets(testDB(t, 1), "sqlite3")
+	CertExpiry(func() *x509.Certificate { return nil })
+	Secrets(testDB(t, 3), "sqlite3")
+	CertExpiry(func() *x509.Certificate { return &x509.Certificate{NotAfter: time.Now().Add(49 * time.Hour)} })
+	if got := gauge(t, "ezb_vault_secrets"); got != 3 {
+		t.Errorf("TestRegisterTwice was incorrect, got: <%v>, want: <%v>.", got, 3)
+	}
+	if got := gauge(t, "ezb_vault_certificate_expiry_days"); got < 2 || got > 2.1 {
+		t.Errorf("TestRegisterTwice was incorrect, got: <%v>, want: <%v>.", got, 2)
+	}
+}
@@ -0,0 +1,183 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// BundleFormat is the version of the export bundle format.
+const BundleFormat = 1
+
+// Import modes, for a key already in the vault.
+const (
+	ImportSkip      = "skip"
+	ImportOverwrite = "overwrite"
+	ImportFail      = "fail"
+)
+
+// ErrBundleKey is returned by Bundle.Open for a wrong passphrase or a
+// modified bundle.
+var ErrBundleKey = errors.New("wrong bundle passphrase or corrupted bundle")
+
+// BundleItem is an exported secret, with its previous values newest first.
+type BundleItem struct {
+	Key      string          `json:"key"`
+	Value    string          `json:"value"`
+	Created  time.Time       `json:"created"`
+	Updated  time.Time       `json:"updated"`
+	Versions []BundleVersion `json:"versions,omitempty"`
+}
+
+// BundleVersion is a previous value of an exported secret.
+type BundleVersion struct {
+	Version int       `json:"version"`
+	Value   string    `json:"value"`
+	Written time.Time `json:"written"`
+}
+
+// Bundle is a portable export of the secrets of an owner. The metadata are in
+// clear, the items sealed with a key derived from a passphrase by scrypt.
+type Bundle struct {
+	Format  int       `json:"format" binding:"required"`
+	Owner   string    `json:"owner"`
+	Source  string    `json:"source"`
+	Created time.Time `json:"created"`
+	Count   int       `json:"count"`
+	Skipped int       `json:"skipped"`
+	KDF     KDF       `json:"kdf"`
+	Data    []byte    `json:"data" binding:"required"`
+}
+
+// KDF is the scrypt parameters of a bundle.
+type KDF struct {
+	Salt []byte `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+// aad bind the metadata to the sealed items.
+func (b Bundle) aad() []byte {
+	return []byte(fmt.Sprintf("ezb_vault bundle %d|%s|%s|%s|%d|%d", b.Format, b.Owner, b.Source, b.Created.UTC().Format(time.RFC3339Nano), b.Count, b.Skipped))
+}
+
+// scrypt parameters of the bundles, 32 MiB of memory. Only these are accepted
+// to open a bundle, a crafted one could ask for gigabytes.
+const (
+	kdfN = 1 << 15
+	kdfR = 8
+	kdfP = 1
+)
+
+func (k KDF) key(passphrase string) ([]byte, error) {
+	if k.N != kdfN || k.R != kdfR || k.P != kdfP || len(k.Salt) < 16 {
+		return nil, ErrBundleKey
+	}
+	return scrypt.Key([]byte(passphrase), k.Salt, k.N, k.R, k.P, 32)
+}
+
+// SealBundle seal items with passphrase.
+func SealBundle(owner, source string, items []BundleItem, skipped int, passphrase string) (Bundle, error) {
+	b := Bundle{
+		Format:  BundleFormat,
+		Owner:   owner,
+		Source:  source,
+		Created: time.Now().UTC(),
+		Count:   len(items),
+		Skipped: skipped,
+		KDF:     KDF{Salt: make([]byte, 16), N: kdfN, R: kdfR, P: kdfP},
+	}
+	if _, err := io.ReadFull(rand.Reader, b.KDF.Salt); err != nil {
+		return b, err
+	}
+	key, err := b.KDF.key(passphrase)
+	if err != nil {
+		return b, err
+	}
+	raw, err := json.Marshal(items)
+	if err != nil {
+		return b, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return b, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return b, err
+	}
+	b.Data = gcm.Seal(nonce, nonce, raw, b.aad())
+	return b, nil
+}
+
+// Open return the items of the bundle.
+func (b Bundle) Open(passphrase string) ([]BundleItem, error) {
+	if b.Format != BundleFormat {
+		return nil, fmt.Errorf("unknown bundle format %d", b.Format)
+	}
+	key, err := b.KDF.key(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.Data) < gcm.NonceSize() {
+		return nil, ErrBundleKey
+	}
+	raw, err := gcm.Open(nil, b.Data[:gcm.NonceSize()], b.Data[gcm.NonceSize():], b.aad())
+	if err != nil {
+		return nil, ErrBundleKey
+	}
+	var items []BundleItem
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, err
+	}
+	if len(items) != b.Count {
+		return nil, ErrBundleKey
+	}
+	return items, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ImportReport tell what an import did, or would do for a dry run.
+type ImportReport struct {
+	Mode        string            `json:"mode"`
+	DryRun      bool              `json:"dryrun"`
+	Created     []string          `json:"created"`
+	Overwritten []string          `json:"overwritten"`
+	Skipped     []string          `json:"skipped"`
+	Conflicts   []string          `json:"conflicts"`
+	Errors      map[string]string `json:"errors,omitempty"`
+}
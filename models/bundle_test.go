@@ -0,0 +1,48 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	items := []BundleItem{{Key: "db-pass", Value: "s3cret", Versions: []BundleVersion{{Version: 1, Value: "old"}}}, {Key: "prod/sql/sa", Value: "p@ss"}}
+	b, err := SealBundle("alice", "vault.local", items, 1, "bundle-pass")
+	if err != nil {
+		t.Fatalf("TestBundle seal failed: %v", err)
+	}
+	got, err := b.Open("bundle-pass")
+	if err != nil || len(got) != 2 || got[1].Value != "p@ss" || len(got[0].Versions) != 1 || got[0].Versions[0].Value != "old" {
+		t.Errorf("TestBundle was incorrect, got: <%v %v>, want: <%v>.", got, err, items)
+	}
+	if _, err := b.Open("wrong-pass"); err != ErrBundleKey {
+		t.Errorf("TestBundle wrong passphrase was incorrect, got: <%v>, want: <%v>.", err, ErrBundleKey)
+	}
+	// the metadata are bound to the sealed items
+	forged := b
+	forged.Owner = "bob"
+	if _, err := forged.Open("bundle-pass"); err != ErrBundleKey {
+		t.Errorf("TestBundle forged owner was incorrect, got: <%v>, want: <%v>.", err, ErrBundleKey)
+	}
+	for _, kdf := range []KDF{{N: 1 << 20, R: 8, P: 1}, {N: 1 << 15, R: 32, P: 1}, {N: 1 << 15, R: 8, P: 16}, {N: 1 << 10, R: 8, P: 1}} {
+		costly := b
+		costly.KDF.N, costly.KDF.R, costly.KDF.P = kdf.N, kdf.R, kdf.P
+		if _, err := costly.Open("bundle-pass"); err != ErrBundleKey {
+			t.Errorf("TestBundle kdf %d,%d,%d was incorrect, got: <%v>, want: <%v>.", kdf.N, kdf.R, kdf.P, err, ErrBundleKey)
+		}
+	}
+}
@@ -21,7 +21,9 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"io"
+	"time"
 )
 
 type KeyVal struct {
@@ -29,17 +31,69 @@ type KeyVal struct {
 	U  string `gorm:"size:250;not null" json:"-"`
 	K  string `gorm:"size:250;not null" json:"key"`
 	V  string `gorm:"not null" sql:"type:text" json:"value"`
+	// DK is the data key encrypting V, wrapped with the owner passphrase.
+	// Empty for secrets written before sharing, V is then encrypted with the passphrase.
+	DK     string `sql:"type:text" json:"-"`
+	Owner  string `gorm:"-" json:"owner,omitempty"`
+	Shared bool   `gorm:"-" json:"shared,omitempty"`
+	// Locked and ExpireAt are set by an administrator.
+	Locked    bool       `json:"-"`
+	ExpireAt  *time.Time `json:"-"`
+	CreatedAt time.Time  `json:"-"`
+	UpdatedAt time.Time  `json:"-"`
 }
 
+// Expired return true if an administrator force-expired the secret.
+func (kv KeyVal) Expired() bool {
+	return kv.ExpireAt != nil && !kv.ExpireAt.After(time.Now())
+}
+
+// KeyMeta describe a secret without its value, for the admin api.
+type KeyMeta struct {
+	Owner     string     `json:"owner"`
+	Key       string     `json:"key"`
+	Locked    bool       `json:"locked"`
+	ExpireAt  *time.Time `json:"expireat,omitempty"`
+	Legacy    bool       `json:"legacy"`
+	Shares    int        `json:"shares"`
+	CreatedAt time.Time  `json:"created"`
+	UpdatedAt time.Time  `json:"updated"`
+}
+
+// Meta return the secret metadata, the value is never copied.
+func (kv KeyVal) Meta(shares int) KeyMeta {
+	return KeyMeta{
+		Owner:     kv.U,
+		Key:       kv.K,
+		Locked:    kv.Locked,
+		ExpireAt:  kv.ExpireAt,
+		Legacy:    kv.DK == "",
+		Shares:    shares,
+		CreatedAt: kv.CreatedAt,
+		UpdatedAt: kv.UpdatedAt,
+	}
+}
+
+// UserCount is the number of secrets of a subject.
+type UserCount struct {
+	U     string `json:"user"`
+	Count int    `json:"keys"`
+}
+
+// ErrLegacy is returned by DataKey for a secret encrypted without data key.
+var ErrLegacy = errors.New("secret without data key")
+
 func createHash(key string) string {
 	hasher := md5.New()
 	hasher.Write([]byte(key))
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
-func (kv KeyVal) Encrypt(passphrase string) KeyVal {
-	data := []byte(kv.V)
-	block, _ := aes.NewCipher([]byte(createHash(passphrase)))
+func seal(key []byte, data []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err.Error())
+	}
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
 		panic(err.Error())
@@ -48,14 +102,10 @@ func (kv KeyVal) Encrypt(passphrase string) KeyVal {
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
 		panic(err.Error())
 	}
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
-	kv.V = string(ciphertext)
-	return kv
+	return gcm.Seal(nonce, nonce, data, nil)
 }
 
-func (kv KeyVal) Decrypt(passphrase string) KeyVal {
-	data := []byte(kv.V)
-	key := []byte(createHash(passphrase))
+func open(key []byte, data []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		panic(err.Error())
@@ -65,8 +115,75 @@ func (kv KeyVal) Decrypt(passphrase string) KeyVal {
 		panic(err.Error())
 	}
 	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// NewDataKey return a random AES-256 key.
+func NewDataKey() []byte {
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		panic(err.Error())
+	}
+	return dek
+}
+
+// WrapKey encrypt a data key with a passphrase.
+func WrapKey(dek []byte, passphrase string) string {
+	return string(seal([]byte(createHash(passphrase)), dek))
+}
+
+// UnwrapKey decrypt a data key wrapped by WrapKey.
+func UnwrapKey(wrapped string, passphrase string) ([]byte, error) {
+	return open([]byte(createHash(passphrase)), []byte(wrapped))
+}
+
+// Encrypt seal V with a new data key, wrapped with passphrase in DK.
+func (kv KeyVal) Encrypt(passphrase string) KeyVal {
+	dek := NewDataKey()
+	kv.DK = WrapKey(dek, passphrase)
+	return kv.EncryptWith(dek)
+}
+
+// EncryptWith seal V with an existing data key, so shares stay valid.
+func (kv KeyVal) EncryptWith(dek []byte) KeyVal {
+	kv.V = string(seal(dek, []byte(kv.V)))
+	return kv
+}
+
+// DataKey unwrap the data key with the owner passphrase.
+func (kv KeyVal) DataKey(passphrase string) ([]byte, error) {
+	if kv.DK == "" {
+		return nil, ErrLegacy
+	}
+	return UnwrapKey(kv.DK, passphrase)
+}
+
+// Decrypt return the secret in clear, or a blank KeyVal if the passphrase is wrong.
+func (kv KeyVal) Decrypt(passphrase string) KeyVal {
+	if kv.DK == "" {
+		plaintext, err := open([]byte(createHash(passphrase)), []byte(kv.V))
+		if err != nil {
+			var blank KeyVal
+			return blank
+		}
+		kv.V = string(plaintext)
+		return kv
+	}
+	dek, err := kv.DataKey(passphrase)
+	if err != nil {
+		var blank KeyVal
+		return blank
+	}
+	return kv.DecryptWith(dek)
+}
+
+// DecryptWith open V with the data key, or return a blank KeyVal.
+func (kv KeyVal) DecryptWith(dek []byte) KeyVal {
+	plaintext, err := open(dek, []byte(kv.V))
 	if err != nil {
 		var blank KeyVal
 		return blank
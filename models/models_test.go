@@ -33,3 +33,38 @@ func TestAES(t *testing.T) {
 		}
 	}
 }
+
+func TestLegacyAES(t *testing.T) {
+	Raw := KeyVal{ID: 0, U: "user0", K: "key0", V: "legacy"}
+	Raw.V = string(seal([]byte(createHash("d4621d373cad")), []byte(Raw.V)))
+	o := Raw.Decrypt("d4621d373cad")
+	if o.V != "legacy" {
+		t.Errorf("TestLegacyAES was incorrect, got: <%s>, want: <%s>.", o.V, "legacy")
+	}
+	if _, err := Raw.DataKey("d4621d373cad"); err != ErrLegacy {
+		t.Errorf("TestLegacyAES DataKey was incorrect, got: <%v>, want: <%v>.", err, ErrLegacy)
+	}
+}
+
+func TestDataKey(t *testing.T) {
+	Raw := KeyVal{ID: 0, U: "user0", K: "key0", V: "shared"}
+	r := Raw.Encrypt("ownerkey")
+	dek, err := r.DataKey("ownerkey")
+	if err != nil {
+		t.Fatalf("TestDataKey unwrap failed: %v", err)
+	}
+	share := WrapKey(dek, "granteekey")
+	gdek, err := UnwrapKey(share, "granteekey")
+	if err != nil {
+		t.Fatalf("TestDataKey grantee unwrap failed: %v", err)
+	}
+	if o := r.DecryptWith(gdek); o.V != Raw.V {
+		t.Errorf("TestDataKey was incorrect, got: <%s>, want: <%s>.", o.V, Raw.V)
+	}
+	if o := r.Decrypt("granteekey"); o.V != "" {
+		t.Errorf("TestDataKey grantee key must not open the owner copy, got: <%s>.", o.V)
+	}
+	if _, err := UnwrapKey(share, "ownerkey"); err == nil {
+		t.Errorf("TestDataKey owner key must not open the grantee copy.")
+	}
+}
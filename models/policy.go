@@ -0,0 +1,114 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	CapRead   = "read"
+	CapWrite  = "write"
+	CapList   = "list"
+	CapDelete = "delete"
+)
+
+// Policy give a subject, or every member of a group, some capabilities on the
+// secrets matching Pattern. Pattern is matched against "owner/key", where * match
+// any sequence of characters, ex: "svc_backup/sql-*" or "*/shared-*".
+type Policy struct {
+	ID           int    `json:"id" gorm:"primary_key"`
+	Name         string `gorm:"size:250;not null" json:"name"`
+	Subject      string `gorm:"size:250" json:"sub,omitempty"`
+	Group        string `gorm:"size:250" json:"group,omitempty"`
+	Pattern      string `gorm:"size:250;not null" json:"pattern"`
+	Capabilities string `gorm:"size:250;not null" json:"capabilities"`
+}
+
+// Validate check the policy before saving it.
+func (p Policy) Validate() error {
+	if p.Name == "" {
+		return errors.New("name is mandatory")
+	}
+	if (p.Subject == "") == (p.Group == "") {
+		return errors.New("one of sub or group is mandatory")
+	}
+	if p.Pattern == "" || !strings.Contains(p.Pattern, "/") {
+		return fmt.Errorf("pattern must look like owner/key, got '%s'", p.Pattern)
+	}
+	for _, cp := range strings.Split(p.Capabilities, ",") {
+		switch strings.TrimSpace(cp) {
+		case CapRead, CapWrite, CapList, CapDelete:
+		default:
+			return fmt.Errorf("unknown capability '%s'", cp)
+		}
+	}
+	return nil
+}
+
+// AppliesTo return true if the policy is attached to the subject or one of its groups.
+func (p Policy) AppliesTo(sub string, groups []string) bool {
+	if p.Subject != "" {
+		return strings.EqualFold(p.Subject, sub)
+	}
+	for _, g := range groups {
+		if strings.EqualFold(p.Group, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow return true if the policy grant capability on owner/key.
+func (p Policy) Allow(owner, key, capability string) bool {
+	if !p.Has(capability) {
+		return false
+	}
+	return Match(p.Pattern, owner+"/"+key)
+}
+
+// Has return true if the policy hold the capability.
+func (p Policy) Has(capability string) bool {
+	for _, cp := range strings.Split(p.Capabilities, ",") {
+		if strings.TrimSpace(cp) == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Match is a minimal glob, only * is special. It's used instead of path.Match
+// because subjects like DOMAIN\user would be seen as escape sequences.
+func Match(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
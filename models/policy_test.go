@@ -0,0 +1,76 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	p := Policy{Name: "backup", Group: "sql-admins", Pattern: `DOMAIN\alice/sql-*`, Capabilities: "read, list"}
+	if err := p.Validate(); err != nil {
+		t.Errorf("TestPolicy Validate was incorrect, got: <%v>.", err)
+	}
+	cases := []struct {
+		owner, key, cp string
+		want           bool
+	}{
+		{`DOMAIN\alice`, "sql-sa", CapRead, true},
+		{`DOMAIN\alice`, "sql-sa", CapList, true},
+		{`DOMAIN\alice`, "sql-sa", CapWrite, false},
+		{`DOMAIN\alice`, "web-sa", CapRead, false},
+		{`DOMAIN\bob`, "sql-sa", CapRead, false},
+	}
+	for _, c := range cases {
+		if got := p.Allow(c.owner, c.key, c.cp); got != c.want {
+			t.Errorf("TestPolicy Allow(%s, %s, %s) was incorrect, got: <%v>, want: <%v>.", c.owner, c.key, c.cp, got, c.want)
+		}
+	}
+	if !p.AppliesTo("svc_job", []string{"users", "SQL-Admins"}) {
+		t.Errorf("TestPolicy AppliesTo was incorrect, group not matched.")
+	}
+	bad := []Policy{
+		{Name: "nosub", Pattern: "a/b", Capabilities: "read"},
+		{Name: "both", Subject: "a", Group: "b", Pattern: "a/b", Capabilities: "read"},
+		{Name: "nopath", Subject: "a", Pattern: "ab", Capabilities: "read"},
+		{Name: "badcap", Subject: "a", Pattern: "a/b", Capabilities: "read,sudo"},
+	}
+	for _, b := range bad {
+		if b.Validate() == nil {
+			t.Errorf("TestPolicy Validate <%s> should fail.", b.Name)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/*", "a/b", true},
+		{"*/shared-*", "bob/shared-db", true},
+		{"*/shared-*", "bob/db", false},
+		{"a/*x*y", "a/1x2y", true},
+		{"a/*x*y", "a/1x2", false},
+		{"a/ab*ba", "a/aba", false},
+	}
+	for _, c := range cases {
+		if got := Match(c.pattern, c.s); got != c.want {
+			t.Errorf("TestMatch(%s, %s) was incorrect, got: <%v>, want: <%v>.", c.pattern, c.s, got, c.want)
+		}
+	}
+}
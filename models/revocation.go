@@ -0,0 +1,34 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import "time"
+
+// Revocation blacklist a single token (JTI) or every token of a subject (SUB).
+type Revocation struct {
+	ID        int       `json:"id" gorm:"primary_key"`
+	JTI       string    `gorm:"size:250;index" json:"jti,omitempty"`
+	SUB       string    `gorm:"size:250;index" json:"sub,omitempty"`
+	Reason    string    `gorm:"size:250" json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created"`
+}
+
+// UsedToken keep track of one-time-use token already consumed, until they expire.
+type UsedToken struct {
+	ID  int    `json:"-" gorm:"primary_key"`
+	JTI string `gorm:"size:250;not null;unique_index" json:"jti"`
+	EXP int64  `json:"exp"`
+}
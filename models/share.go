@@ -0,0 +1,58 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Share give a subject, or a group, read access to one secret. DK is the secret
+// data key wrapped with the grantee passphrase, the owner one is never shared.
+type Share struct {
+	ID        int       `json:"id" gorm:"primary_key"`
+	KeyValID  int       `gorm:"not null;index" json:"-"`
+	Grantee   string    `gorm:"size:250;not null" json:"grantee"`
+	IsGroup   bool      `json:"group"`
+	DK        string    `gorm:"not null" sql:"type:text" json:"-"`
+	CreatedAt time.Time `json:"created"`
+}
+
+// ShareRequest is the body of a new share. Passphrase is the grantee
+// EZB-VAULT-SHARE-KEY, given to it out of band.
+type ShareRequest struct {
+	Grantee    string `json:"grantee" binding:"required"`
+	IsGroup    bool   `json:"group"`
+	Passphrase string `json:"passphrase" binding:"required"`
+}
+
+// FindShare return owner/key and its share granted to sub or one of its groups.
+func FindShare(db *gorm.DB, owner, key, sub string, groups []string) (kv KeyVal, sh Share, err error) {
+	if err = db.Where("u = ? AND k = ?", owner, key).First(&kv).Error; err != nil {
+		return kv, sh, err
+	}
+	err = db.Where("key_val_id = ? AND ((is_group = ? AND grantee = ?) OR (is_group = ? AND grantee IN (?)))",
+		kv.ID, false, sub, true, append([]string{""}, groups...)).First(&sh).Error
+	return kv, sh, err
+}
+
+// SharedWith return every share granted to sub or one of its groups.
+func SharedWith(db *gorm.DB, sub string, groups []string) (shares []Share, err error) {
+	err = db.Where("(is_group = ? AND grantee = ?) OR (is_group = ? AND grantee IN (?))",
+		false, sub, true, append([]string{""}, groups...)).Find(&shares).Error
+	return shares, err
+}
@@ -0,0 +1,43 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import "time"
+
+// Status is returned by /sys/status. Scope is vault for an administrator,
+// the counts are then of the whole vault, else own and the counts are of the
+// secrets of the caller, without users and policies.
+type Status struct {
+	Version     string      `json:"version"`
+	Started     time.Time   `json:"started"`
+	Uptime      string      `json:"uptime"`
+	Storage     string      `json:"storage"`
+	Scope       string      `json:"scope"`
+	Users       int         `json:"users,omitempty"`
+	Keys        int         `json:"keys"`
+	Locked      int         `json:"locked"`
+	Expired     int         `json:"expired"`
+	Shares      int         `json:"shares"`
+	Policies    int         `json:"policies,omitempty"`
+	Certificate *CertStatus `json:"certificate,omitempty"`
+}
+
+// CertStatus is the served certificate expiry.
+type CertStatus struct {
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"notafter"`
+	DaysLeft int       `json:"daysleft"`
+}
@@ -0,0 +1,80 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// MaxVersions is the number of previous values kept per secret.
+const MaxVersions = 10
+
+// Version is a previous value of a secret, sealed with the same data key, so
+// the owner and the grantees open it like the current one. N count the values
+// of the secret from 1, Written is when the value was set.
+type Version struct {
+	ID       int       `json:"-" gorm:"primary_key"`
+	KeyValID int       `gorm:"not null;index" json:"-"`
+	N        int       `gorm:"not null" json:"version"`
+	V        string    `gorm:"not null" sql:"type:text" json:"value"`
+	Written  time.Time `json:"written"`
+	Current  bool      `gorm:"-" json:"current,omitempty"`
+}
+
+// AddVersion keep kv, as stored before its update, and drop the versions over
+// MaxVersions. V must be sealed with the data key of kv.
+func AddVersion(db *gorm.DB, kv KeyVal) error {
+	var last Version
+	n := 1
+	if err := db.Where("key_val_id = ?", kv.ID).Order("n desc").First(&last).Error; err == nil {
+		n = last.N + 1
+	} else if !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	written := kv.UpdatedAt
+	if written.IsZero() {
+		written = kv.CreatedAt
+	}
+	if err := db.Create(&Version{KeyValID: kv.ID, N: n, V: kv.V, Written: written}).Error; err != nil {
+		return err
+	}
+	return db.Where("key_val_id = ? AND n <= ?", kv.ID, n-MaxVersions).Delete(Version{}).Error
+}
+
+// Versions return the previous values of the secret id, newest first, sealed.
+func Versions(db *gorm.DB, id int) (versions []Version, err error) {
+	err = db.Where("key_val_id = ?", id).Order("n desc").Find(&versions).Error
+	return versions, err
+}
+
+// EncryptWith seal V with the data key of its secret.
+func (v Version) EncryptWith(dek []byte) Version {
+	v.V = string(seal(dek, []byte(v.V)))
+	return v
+}
+
+// DecryptWith open V with the data key, or return a blank Version.
+func (v Version) DecryptWith(dek []byte) Version {
+	plaintext, err := open(dek, []byte(v.V))
+	if err != nil {
+		var blank Version
+		return blank
+	}
+	v.V = string(plaintext)
+	return v
+}
@@ -0,0 +1,114 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ezbastion/ezb_vault/Middleware"
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/ezbastion/ezb_vault/models"
+	"github.com/urfave/cli"
+)
+
+// policyCommand manage the acl policies from the console. The running
+// service pick up the changes on its next tick.
+func policyCommand() cli.Command {
+	return cli.Command{
+		Name:  "policy",
+		Usage: "Manage acl policies on secrets.",
+		Before: func(c *cli.Context) error {
+			if firstcall {
+				return fmt.Errorf("ezb_vault not initialized")
+			}
+			return nil
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:  "add",
+				Usage: "Add a policy to a subject (--sub) or a group (--group).",
+				Flags: []cli.Flag{
+					cli.StringFlag{Name: "name", Usage: "policy name"},
+					cli.StringFlag{Name: "sub", Usage: "token subject"},
+					cli.StringFlag{Name: "group", Usage: "group from the token groups claim"},
+					cli.StringFlag{Name: "pattern", Usage: "owner/key pattern, * as wildcard"},
+					cli.StringFlag{Name: "cap", Value: "read,list", Usage: "comma separated list of read, write, list, delete"},
+				},
+				Action: func(c *cli.Context) error {
+					db, err := configuration.InitDB(conf, exPath)
+					if err != nil {
+						return err
+					}
+					defer db.Close()
+					ps, err := Middleware.NewPolicyStore(db)
+					if err != nil {
+						return err
+					}
+					p := models.Policy{
+						Name:         c.String("name"),
+						Subject:      c.String("sub"),
+						Group:        c.String("group"),
+						Pattern:      c.String("pattern"),
+						Capabilities: c.String("cap"),
+					}
+					if err := ps.Save(&p); err != nil {
+						return err
+					}
+					fmt.Printf("policy #%d added\n", p.ID)
+					return nil
+				},
+			}, {
+				Name:  "list",
+				Usage: "List policies.",
+				Action: func(c *cli.Context) error {
+					db, err := configuration.InitDB(conf, exPath)
+					if err != nil {
+						return err
+					}
+					defer db.Close()
+					ps, err := Middleware.NewPolicyStore(db)
+					if err != nil {
+						return err
+					}
+					for _, p := range ps.List() {
+						fmt.Printf("#%d\t%s\tsub:%s\tgroup:%s\t%s\t%s\n", p.ID, p.Name, p.Subject, p.Group, p.Pattern, p.Capabilities)
+					}
+					return nil
+				},
+			}, {
+				Name:      "remove",
+				Usage:     "Remove a policy by id.",
+				ArgsUsage: "<id>",
+				Action: func(c *cli.Context) error {
+					db, err := configuration.InitDB(conf, exPath)
+					if err != nil {
+						return err
+					}
+					defer db.Close()
+					ps, err := Middleware.NewPolicyStore(db)
+					if err != nil {
+						return err
+					}
+					var id int
+					if _, err := fmt.Sscan(c.Args().First(), &id); err != nil {
+						return fmt.Errorf("bad policy id: %s", c.Args().First())
+					}
+					return ps.Delete(id)
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,369 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package proxy is a local cache in front of a vault. The GET answers are kept
+// a short time, sealed with a key derived from the token and the passphrases
+// of the request, and served stale when the vault cannot be reached.
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderCache tell if an answer is a cache hit, miss or stale.
+const HeaderCache = "X-Ezb-Vault-Cache"
+
+// forwarded are the request headers sent to the vault.
+var forwarded = []string{"Authorization", "Content-Type", "EZB-VAULT-KEY", "EZB-VAULT-SHARE-KEY", "EZB-VAULT-BUNDLE-KEY"}
+
+// Config set a Proxy. Upstream is the vault url. A GET answer is cached TTL
+// (30s by default) and served Stale more (5m by default, negative for never)
+// while the vault is unreachable.
+type Config struct {
+	Upstream   string
+	HTTPClient *http.Client
+	TTL        time.Duration
+	Stale      time.Duration
+}
+
+// Proxy forward the requests to the vault and cache the GET answers.
+type Proxy struct {
+	upstream string
+	http     *http.Client
+	ttl      time.Duration
+	stale    time.Duration
+	secret   []byte
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	flights map[string]*flight
+	swept   time.Time
+}
+
+// entry is a cached answer, the body is sealed. It is never served after the
+// token expiry exp.
+type entry struct {
+	status  int
+	header  http.Header
+	nonce   []byte
+	sealed  []byte
+	expires time.Time
+	exp     time.Time
+}
+
+// answer is a vault answer in clear.
+type answer struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// flight is a vault request shared by the identical requests arrived meanwhile.
+type flight struct {
+	wg  sync.WaitGroup
+	res *answer
+	err error
+}
+
+// New return a Proxy for conf, with a new random sealing secret.
+func New(conf Config) (*Proxy, error) {
+	u, err := url.Parse(conf.Upstream)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("vault address must be https://host:port, got '%s'", conf.Upstream)
+	}
+	p := &Proxy{
+		upstream: strings.TrimSuffix(conf.Upstream, "/"),
+		http:     conf.HTTPClient,
+		ttl:      conf.TTL,
+		stale:    conf.Stale,
+		secret:   make([]byte, 32),
+		now:      time.Now,
+		entries:  map[string]*entry{},
+		flights:  map[string]*flight{},
+	}
+	if p.http == nil {
+		p.http = http.DefaultClient
+	}
+	if p.ttl <= 0 {
+		p.ttl = 30 * time.Second
+	}
+	if p.stale < 0 {
+		p.stale = 0
+	} else if p.stale == 0 {
+		p.stale = 5 * time.Minute
+	}
+	if _, err := rand.Read(p.secret); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Purge drop the cache.
+func (p *Proxy) Purge() {
+	p.mu.Lock()
+	p.entries = map[string]*entry{}
+	p.mu.Unlock()
+}
+
+// Len return the number of cached answers.
+func (p *Proxy) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entries)
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	uri := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		uri += "?" + r.URL.RawQuery
+	}
+	if r.Method != http.MethodGet {
+		res, err := p.forward(r.Context(), r, uri)
+		if err != nil {
+			unreachable(w)
+			return
+		}
+		// a change may show in any cached list or shared secret
+		if res.status < 300 {
+			p.Purge()
+		}
+		write(w, res, "")
+		return
+	}
+	// a one-time token must reach the vault, and an answer must not outlive
+	// the token. The claims are not verified, the vault does it.
+	exp, once, ok := claims(r)
+	if !ok || once || !p.now().Before(exp) {
+		res, err := p.forward(r.Context(), r, uri)
+		if err != nil {
+			unreachable(w)
+			return
+		}
+		write(w, res, "")
+		return
+	}
+	id, key := p.keys(r, uri)
+	cached, res := p.lookup(id, key)
+	if res != nil && p.now().Before(cached.expires) {
+		write(w, res, "hit")
+		return
+	}
+	fresh, err := p.fetch(r, uri, id)
+	if err == nil && !unavailable(fresh) {
+		if fresh.status == http.StatusOK {
+			p.store(id, key, fresh, exp)
+		}
+		write(w, fresh, "miss")
+		return
+	}
+	if res != nil && p.now().Before(cached.expires.Add(p.stale)) {
+		write(w, res, "stale")
+		return
+	}
+	if err != nil {
+		unreachable(w)
+		return
+	}
+	write(w, fresh, "miss")
+}
+
+// claims return the exp and once claims of the bearer token, ok is false if
+// the token is missing, unreadable or has no exp.
+func claims(r *http.Request) (exp time.Time, once bool, ok bool) {
+	bearer := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(bearer) != 2 {
+		return exp, false, false
+	}
+	parts := strings.Split(bearer[1], ".")
+	if len(parts) != 3 {
+		return exp, false, false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return exp, false, false
+	}
+	var c struct {
+		Exp  int64 `json:"exp"`
+		Once bool  `json:"once"`
+	}
+	if err := json.Unmarshal(b, &c); err != nil || c.Exp == 0 {
+		return exp, false, false
+	}
+	return time.Unix(c.Exp, 0), c.Once, true
+}
+
+// keys return the cache id of the request and the key sealing its answer,
+// both bound to the credentials of the request.
+func (p *Proxy) keys(r *http.Request, uri string) (string, []byte) {
+	cred := strings.Join([]string{r.Header.Get("Authorization"), r.Header.Get("EZB-VAULT-KEY"), r.Header.Get("EZB-VAULT-SHARE-KEY"), r.Header.Get("EZB-VAULT-BUNDLE-KEY")}, "\x00")
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte("id\x00" + cred + "\x00" + uri))
+	id := hex.EncodeToString(mac.Sum(nil))
+	mac = hmac.New(sha256.New, p.secret)
+	mac.Write([]byte("key\x00" + cred))
+	return id, mac.Sum(nil)
+}
+
+// lookup return the entry of id and its answer, nil if none or not opened.
+func (p *Proxy) lookup(id string, key []byte) (*entry, *answer) {
+	p.mu.Lock()
+	e := p.entries[id]
+	p.mu.Unlock()
+	if e == nil || !p.now().Before(e.exp) {
+		return nil, nil
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil
+	}
+	body, err := gcm.Open(nil, e.nonce, e.sealed, []byte(id))
+	if err != nil {
+		return nil, nil
+	}
+	return e, &answer{status: e.status, header: e.header, body: body}
+}
+
+func (p *Proxy) store(id string, key []byte, res *answer, exp time.Time) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return
+	}
+	e := &entry{status: res.status, header: res.header, nonce: make([]byte, gcm.NonceSize()), expires: p.now().Add(p.ttl), exp: exp}
+	if _, err := rand.Read(e.nonce); err != nil {
+		return
+	}
+	e.sealed = gcm.Seal(nil, e.nonce, res.body, []byte(id))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries[id] = e
+	// drop the entries too old to be served stale, once per ttl
+	now := p.now()
+	if now.Sub(p.swept) > p.ttl {
+		p.swept = now
+		for k, old := range p.entries {
+			if now.After(old.expires.Add(p.stale)) || !now.Before(old.exp) {
+				delete(p.entries, k)
+			}
+		}
+	}
+}
+
+// fetch forward a GET, once for the identical requests in flight.
+func (p *Proxy) fetch(r *http.Request, uri, id string) (*answer, error) {
+	p.mu.Lock()
+	if f, ok := p.flights[id]; ok {
+		p.mu.Unlock()
+		f.wg.Wait()
+		return f.res, f.err
+	}
+	f := &flight{}
+	f.wg.Add(1)
+	p.flights[id] = f
+	p.mu.Unlock()
+
+	// not bound to the first client, the others wait for the answer too
+	f.res, f.err = p.forward(context.Background(), r, uri)
+	p.mu.Lock()
+	delete(p.flights, id)
+	p.mu.Unlock()
+	f.wg.Done()
+	return f.res, f.err
+}
+
+func (p *Proxy) forward(ctx context.Context, r *http.Request, uri string) (*answer, error) {
+	req, err := http.NewRequest(r.Method, p.upstream+uri, r.Body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = r.ContentLength
+	for _, h := range forwarded {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, err
+	}
+	header := resp.Header.Clone()
+	for _, h := range []string{"Content-Length", "Connection", "Transfer-Encoding", "Date"} {
+		header.Del(h)
+	}
+	return &answer{status: resp.StatusCode, header: header, body: body}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// unavailable is an answer of a vault down or restarting. A 503 with a #V
+// code is the vault refusing the request, like #V0019 when the audit record
+// was not written, the cache must not answer instead.
+func unavailable(res *answer) bool {
+	switch res.status {
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true
+	case http.StatusServiceUnavailable:
+		return !bytes.HasPrefix(bytes.TrimSpace(res.body), []byte(`"#V`))
+	}
+	return false
+}
+
+func unreachable(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadGateway)
+	w.Write([]byte(strconv.Quote("vault unreachable")))
+}
+
+func write(w http.ResponseWriter, res *answer, cache string) {
+	for k, v := range res.header {
+		w.Header()[k] = v
+	}
+	if cache != "" {
+		w.Header().Set(HeaderCache, cache)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(res.body)))
+	w.WriteHeader(res.status)
+	io.Copy(w, bytes.NewReader(res.body))
+}
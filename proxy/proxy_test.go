@@ -0,0 +1,217 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package proxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testVault answer the EZB-VAULT-KEY of the request, or 503 when down.
+type testVault struct {
+	calls  int32
+	down   int32
+	delay  time.Duration
+	refuse string
+}
+
+func (v *testVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&v.calls, 1)
+	time.Sleep(v.delay)
+	if atomic.LoadInt32(&v.down) == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if v.refuse != "" {
+			w.Write([]byte(`"` + v.refuse + `"`))
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"key":"` + r.URL.EscapedPath() + `","value":"` + r.Header.Get("EZB-VAULT-KEY") + r.Header.Get("EZB-VAULT-BUNDLE-KEY") + `"}`))
+}
+
+// token return an unsigned jwt with exp and once, the proxy does not check
+// the signature.
+func token(exp time.Time, once bool) string {
+	payload := fmt.Sprintf(`{"sub":"alice","exp":%d,"once":%t}`, exp.Unix(), once)
+	return "e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+var bearer = token(time.Now().Add(time.Hour), false)
+
+func get(t *testing.T, srv *httptest.Server, method, path, key string) (int, string, string) {
+	return getAs(t, srv, bearer, method, path, key)
+}
+
+func getAs(t *testing.T, srv *httptest.Server, tok, method, path, key string) (int, string, string) {
+	req, _ := http.NewRequest(method, srv.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set("EZB-VAULT-KEY", key)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header.Get(HeaderCache), string(body)
+}
+
+func newProxy(t *testing.T, v *testVault) (*Proxy, *httptest.Server, *time.Time) {
+	up := httptest.NewServer(v)
+	t.Cleanup(up.Close)
+	p, err := New(Config{Upstream: up.URL, TTL: time.Minute, Stale: 5 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+	return p, srv, &now
+}
+
+func TestCache(t *testing.T) {
+	v := &testVault{}
+	p, srv, now := newProxy(t, v)
+	for i, want := range []string{"miss", "hit"} {
+		if _, cache, body := get(t, srv, http.MethodGet, "/prod%2Fsql", "k1"); cache != want || !strings.Contains(body, `"value":"k1"`) || !strings.Contains(body, "prod%2Fsql") {
+			t.Errorf("TestCache %d was incorrect, got: <%s %s>, want: <%s>.", i, cache, body, want)
+		}
+	}
+	// another passphrase never get the cached answer
+	if _, cache, body := get(t, srv, http.MethodGet, "/prod%2Fsql", "k2"); cache != "miss" || !strings.Contains(body, `"value":"k2"`) {
+		t.Errorf("TestCache other key was incorrect, got: <%s %s>, want: <%s>.", cache, body, "miss")
+	}
+	*now = now.Add(2 * time.Minute)
+	if _, cache, _ := get(t, srv, http.MethodGet, "/prod%2Fsql", "k1"); cache != "miss" {
+		t.Errorf("TestCache expired was incorrect, got: <%s>, want: <%s>.", cache, "miss")
+	}
+	if calls := atomic.LoadInt32(&v.calls); calls != 3 {
+		t.Errorf("TestCache vault calls was incorrect, got: <%d>, want: <%d>.", calls, 3)
+	}
+	get(t, srv, http.MethodDelete, "/other", "k1")
+	if p.Len() != 0 {
+		t.Errorf("TestCache purge was incorrect, got: <%d> entries, want: <0>.", p.Len())
+	}
+}
+
+func TestStale(t *testing.T) {
+	v := &testVault{}
+	_, srv, now := newProxy(t, v)
+	get(t, srv, http.MethodGet, "/db", "k1")
+	atomic.StoreInt32(&v.down, 1)
+	*now = now.Add(2 * time.Minute)
+	if status, cache, body := get(t, srv, http.MethodGet, "/db", "k1"); status != http.StatusOK || cache != "stale" || !strings.Contains(body, "k1") {
+		t.Errorf("TestStale was incorrect, got: <%d %s %s>, want: <%d %s>.", status, cache, body, http.StatusOK, "stale")
+	}
+	*now = now.Add(10 * time.Minute)
+	if status, _, _ := get(t, srv, http.MethodGet, "/db", "k1"); status != http.StatusServiceUnavailable {
+		t.Errorf("TestStale too old was incorrect, got: <%d>, want: <%d>.", status, http.StatusServiceUnavailable)
+	}
+	if status, _, _ := get(t, srv, http.MethodGet, "/never", "k1"); status != http.StatusServiceUnavailable {
+		t.Errorf("TestStale uncached was incorrect, got: <%d>, want: <%d>.", status, http.StatusServiceUnavailable)
+	}
+}
+
+// The audit of the vault is fail closed and refuse the read, the cached
+// answer must not be served.
+func TestStaleRefused(t *testing.T) {
+	v := &testVault{refuse: "#V0019"}
+	_, srv, now := newProxy(t, v)
+	get(t, srv, http.MethodGet, "/db", "k1")
+	atomic.StoreInt32(&v.down, 1)
+	*now = now.Add(2 * time.Minute)
+	if status, cache, body := get(t, srv, http.MethodGet, "/db", "k1"); status != http.StatusServiceUnavailable || cache == "stale" || !strings.Contains(body, "#V0019") {
+		t.Errorf("TestStaleRefused was incorrect, got: <%d %s %s>, want: <%d %s>.", status, cache, body, http.StatusServiceUnavailable, "#V0019")
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	v := &testVault{delay: 300 * time.Millisecond}
+	_, srv, _ := newProxy(t, v)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get(t, srv, http.MethodGet, "/db", "k1")
+		}()
+	}
+	wg.Wait()
+	if calls := atomic.LoadInt32(&v.calls); calls != 1 {
+		t.Errorf("TestCoalesce vault calls was incorrect, got: <%d>, want: <%d>.", calls, 1)
+	}
+}
+
+func TestTokenClaims(t *testing.T) {
+	v := &testVault{}
+	p, srv, now := newProxy(t, v)
+	once := token(now.Add(time.Hour), true)
+	for i := 0; i < 2; i++ {
+		if _, cache, _ := getAs(t, srv, once, http.MethodGet, "/db", "k1"); cache != "" {
+			t.Errorf("TestTokenClaims once was incorrect, got: <%s>, want: <not cached>.", cache)
+		}
+	}
+	if _, cache, _ := getAs(t, srv, "opaque", http.MethodGet, "/db", "k1"); cache != "" || p.Len() != 0 {
+		t.Errorf("TestTokenClaims no exp was incorrect, got: <%s> and %d entries, want: <not cached>.", cache, p.Len())
+	}
+	// the token expire before the ttl and the stale delay
+	short := token(now.Add(30*time.Second), false)
+	getAs(t, srv, short, http.MethodGet, "/db", "k1")
+	if _, cache, _ := getAs(t, srv, short, http.MethodGet, "/db", "k1"); cache != "hit" {
+		t.Errorf("TestTokenClaims was incorrect, got: <%s>, want: <%s>.", cache, "hit")
+	}
+	*now = now.Add(40 * time.Second)
+	if _, cache, _ := getAs(t, srv, short, http.MethodGet, "/db", "k1"); cache != "" {
+		t.Errorf("TestTokenClaims expired was incorrect, got: <%s>, want: <not cached>.", cache)
+	}
+	atomic.StoreInt32(&v.down, 1)
+	if status, cache, _ := getAs(t, srv, short, http.MethodGet, "/db", "k1"); status != http.StatusServiceUnavailable || cache == "stale" {
+		t.Errorf("TestTokenClaims stale was incorrect, got: <%d %s>, want: <%d>.", status, cache, http.StatusServiceUnavailable)
+	}
+	if calls := atomic.LoadInt32(&v.calls); calls != 6 {
+		t.Errorf("TestTokenClaims vault calls was incorrect, got: <%d>, want: <%d>.", calls, 6)
+	}
+}
+
+// An export is sealed with the bundle passphrase, another passphrase must
+// reach the vault.
+func TestBundleKey(t *testing.T) {
+	v := &testVault{}
+	_, srv, _ := newProxy(t, v)
+	for _, bkey := range []string{"bundle-1", "bundle-2"} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/sys/export", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		req.Header.Set("EZB-VAULT-KEY", "k1")
+		req.Header.Set("EZB-VAULT-BUNDLE-KEY", bkey)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if cache := resp.Header.Get(HeaderCache); cache != "miss" || !strings.Contains(string(body), `"k1`+bkey+`"`) {
+			t.Errorf("TestBundleKey %s was incorrect, got: <%s %s>, want: <%s>.", bkey, cache, body, "miss")
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/client"
+	"github.com/ezbastion/ezb_vault/proxy"
+	"github.com/urfave/cli"
+)
+
+// proxyListen listen on a unix:/path socket, only the owner can use it, or on
+// a loopback address.
+func proxyListen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		socket := strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//")
+		// a socket left by a previous run
+		if fi, err := os.Lstat(socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(socket)
+		}
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(socket, 0600); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("the proxy listen on a loopback address or a unix socket only, got '%s'", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// proxyCommand run a local cache in front of a vault.
+func proxyCommand() cli.Command {
+	return cli.Command{
+		Name:  "proxy",
+		Usage: "Run a local caching proxy of a vault.",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "listen", Value: "127.0.0.1:5300", Usage: "loopback host:port or unix:/path socket"},
+			cli.StringFlag{Name: "addr", EnvVar: "EZB_VAULT_ADDR", Usage: "vault url, https://host:port"},
+			cli.StringFlag{Name: "ca", EnvVar: "EZB_VAULT_CA", Usage: "CA certificate of the vault, the system ones by default"},
+			cli.DurationFlag{Name: "ttl", Value: 30 * time.Second, Usage: "time a secret is cached"},
+			cli.DurationFlag{Name: "stale", Value: 5 * time.Minute, Usage: "time an expired secret is served while the vault is unreachable, 0 for never"},
+		},
+		Action: func(c *cli.Context) error {
+			if c.String("addr") == "" {
+				return kvExit(fmt.Errorf("vault address is required, --addr or EZB_VAULT_ADDR"))
+			}
+			hc, err := client.NewHTTPClient(c.String("addr"), c.String("ca"), 10*time.Second)
+			if err != nil {
+				return kvExit(err)
+			}
+			stale := c.Duration("stale")
+			if stale == 0 {
+				stale = -1
+			}
+			p, err := proxy.New(proxy.Config{Upstream: c.String("addr"), HTTPClient: hc, TTL: c.Duration("ttl"), Stale: stale})
+			if err != nil {
+				return kvExit(err)
+			}
+			l, err := proxyListen(c.String("listen"))
+			if err != nil {
+				return kvExit(err)
+			}
+			srv := &http.Server{Handler: p, ReadHeaderTimeout: 10 * time.Second}
+			quit := make(chan os.Signal, 1)
+			signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(quit)
+			go func() {
+				<-quit
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				srv.Shutdown(ctx)
+			}()
+			fmt.Fprintf(os.Stderr, "ezb_vault proxy listening on %s\n", c.String("listen"))
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				return kvExit(err)
+			}
+			return nil
+		},
+	}
+}
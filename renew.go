@@ -0,0 +1,143 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ezbastion/ezb_lib/certmanager"
+	"github.com/ezbastion/ezb_lib/logmanager"
+	"github.com/ezbastion/ezb_vault/audit"
+	"github.com/ezbastion/ezb_vault/configuration"
+)
+
+// certIssuer request a certificate pair valid days, written to certFile and
+// keyFile. pkiIssuer ask ezb_pki, tests use a local one.
+type certIssuer func(conf configuration.Configuration, days int, certFile, keyFile, caFile string) error
+
+func pkiIssuer(conf configuration.Configuration, days int, certFile, keyFile, caFile string) error {
+	request := certmanager.NewCertificateRequest(conf.ServiceName, days, conf.SAN)
+	return certmanager.Generate(request, conf.EzbPki, certFile, keyFile, caFile)
+}
+
+// renewer replace the server certificate when it expire in less than
+// conf.Renew.Before days.
+type renewer struct {
+	mu     sync.Mutex
+	certs  *certStore
+	live   *configuration.Live
+	exPath string
+	issue  certIssuer
+	audit  *audit.Logger
+	now    func() time.Time
+}
+
+// Check renew the certificate if needed, a failure is logged and audited, and
+// retried on the next check.
+func (r *renewer) Check() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	conf := r.live.Get()
+	if conf.Renew.Disabled {
+		return
+	}
+	leaf := r.certs.Leaf()
+	if leaf == nil {
+		return
+	}
+	left := leaf.NotAfter.Sub(r.now())
+	if left > conf.Renew.Threshold() {
+		return
+	}
+	logmanager.Info(fmt.Sprintf("certificate %s expire on %s, renewal requested to %s", leaf.Subject.CommonName, leaf.NotAfter, conf.EzbPki))
+	err := r.renew(conf)
+	rec := audit.Record{Action: "system:cert-renew", Key: leaf.Subject.CommonName, Result: "success"}
+	if err != nil {
+		rec.Result, rec.Error = "failure", err.Error()
+		logmanager.Error(fmt.Sprintf("certificate renewal failed, it expire in %s: %s", left.Round(time.Minute), err.Error()))
+	} else {
+		logmanager.Info(fmt.Sprintf("certificate renewed, valid until %s", r.certs.Leaf().NotAfter))
+	}
+	if r.audit != nil {
+		if err := r.audit.Log(rec); err != nil {
+			logmanager.Error(fmt.Sprintf("unable to write audit record: %s", err.Error()))
+		}
+	}
+}
+
+// renew get the new pair in .new files, check it, keep the old files as .old
+// and swap the served certificate. The old files are put back if the swap or
+// the load of the new pair fail.
+func (r *renewer) renew(conf configuration.Configuration) error {
+	certFile := path.Join(r.exPath, conf.PublicCert)
+	keyFile := path.Join(r.exPath, conf.PrivateKey)
+	caFile := path.Join(r.exPath, conf.CaCert)
+	defer os.Remove(certFile + ".new")
+	defer os.Remove(keyFile + ".new")
+	defer os.Remove(caFile + ".new")
+	if err := r.issue(conf, conf.Renew.Validity(), certFile+".new", keyFile+".new", caFile+".new"); err != nil {
+		return err
+	}
+	pair, err := tls.LoadX509KeyPair(certFile+".new", keyFile+".new")
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if err := checkCert(leaf, conf.SAN, r.now()); err != nil {
+		return err
+	}
+	files := []string{certFile, keyFile}
+	if _, err := os.Stat(caFile + ".new"); err == nil {
+		files = append(files, caFile)
+	}
+	var kept, placed []string
+	rollback := func(err error) error {
+		for _, f := range placed {
+			os.Remove(f)
+		}
+		for _, f := range kept {
+			if rerr := os.Rename(f+".old", f); rerr != nil {
+				logmanager.Error(fmt.Sprintf("unable to restore %s: %s", f, rerr.Error()))
+			}
+		}
+		return err
+	}
+	for _, f := range files {
+		if _, err := os.Stat(f); err == nil {
+			if err := os.Rename(f, f+".old"); err != nil {
+				return rollback(err)
+			}
+			kept = append(kept, f)
+		}
+		if err := os.Rename(f+".new", f); err != nil {
+			return rollback(err)
+		}
+		placed = append(placed, f)
+	}
+	if err := r.certs.Load(conf, r.exPath); err != nil {
+		return rollback(err)
+	}
+	return nil
+}
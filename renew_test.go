@@ -0,0 +1,208 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/audit"
+	"github.com/ezbastion/ezb_vault/configuration"
+)
+
+// testPKI stand for ezb_pki, it sign the requests with a local ca.
+type testPKI struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+	san  []string
+	err  error
+	// delay the validity of the issued certificates
+	delay time.Duration
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testPKI{key: key, cert: cert}
+}
+
+func (p *testPKI) write(t *testing.T, name string, san []string, days int, certFile, keyFile string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     san,
+		NotBefore:    time.Now().Add(p.delay - time.Hour),
+		NotAfter:     time.Now().Add(p.delay + time.Duration(days)*24*time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, p.cert, &key.PublicKey, p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600)
+}
+
+func (p *testPKI) issuer(t *testing.T) certIssuer {
+	return func(conf configuration.Configuration, days int, certFile, keyFile, caFile string) error {
+		if p.err != nil {
+			return p.err
+		}
+		san := conf.SAN
+		if p.san != nil {
+			san = p.san
+		}
+		p.write(t, conf.ServiceName, san, days, certFile, keyFile)
+		return ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.cert.Raw}), 0600)
+	}
+}
+
+func testRenewer(t *testing.T, days int) (*renewer, *testPKI, string) {
+	dir, err := ioutil.TempDir("", "renew")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "cert"), 0700)
+	conf := configuration.Configuration{
+		ServiceName: "ezb_vault",
+		PublicCert:  "cert/ezb_vault.crt",
+		PrivateKey:  "cert/ezb_vault.key",
+		CaCert:      "cert/ca.crt",
+		SAN:         []string{"vault.local"},
+	}
+	pki := newTestPKI(t)
+	pki.write(t, "ezb_vault", conf.SAN, days, filepath.Join(dir, conf.PublicCert), filepath.Join(dir, conf.PrivateKey))
+	certs := &certStore{}
+	if err := certs.Load(conf, dir); err != nil {
+		t.Fatal(err)
+	}
+	al, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &renewer{certs: certs, live: configuration.NewLive(conf, dir), exPath: dir, issue: pki.issuer(t), audit: al, now: time.Now}
+	return r, pki, dir
+}
+
+func TestRenew(t *testing.T) {
+	r, _, dir := testRenewer(t, 10)
+	defer os.RemoveAll(dir)
+	defer r.audit.Close()
+	before := r.certs.Leaf().NotAfter
+	r.Check()
+	after := r.certs.Leaf().NotAfter
+	if !after.After(before.Add(700 * 24 * time.Hour)) {
+		t.Errorf("TestRenew was incorrect, got: <%s>, want: <renewed for 730 days>.", after)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cert", "ezb_vault.crt.old")); err != nil {
+		t.Errorf("TestRenew was incorrect, old certificate not kept: %v.", err)
+	}
+	raw, _ := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	if !strings.Contains(string(raw), `"action":"system:cert-renew"`) || !strings.Contains(string(raw), `"result":"success"`) {
+		t.Errorf("TestRenew was incorrect, renewal not audited: <%s>.", raw)
+	}
+}
+
+func TestRenewNotNeeded(t *testing.T) {
+	r, pki, dir := testRenewer(t, 60)
+	defer os.RemoveAll(dir)
+	defer r.audit.Close()
+	pki.err = errors.New("must not be called")
+	before := r.certs.Leaf().NotAfter
+	r.Check()
+	if got := r.certs.Leaf().NotAfter; !got.Equal(before) {
+		t.Errorf("TestRenewNotNeeded was incorrect, got: <%s>, want: <%s>.", got, before)
+	}
+}
+
+func TestRenewFailure(t *testing.T) {
+	r, pki, dir := testRenewer(t, 10)
+	defer os.RemoveAll(dir)
+	defer r.audit.Close()
+	before := r.certs.Leaf().NotAfter
+	pki.err = errors.New("pki unreachable")
+	r.Check()
+	pki.err = nil
+	pki.san = []string{"other.local"}
+	r.Check()
+	if got := r.certs.Leaf().NotAfter; !got.Equal(before) {
+		t.Errorf("TestRenewFailure was incorrect, got: <%s>, want: <%s>.", got, before)
+	}
+	raw, _ := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	if strings.Count(string(raw), `"result":"failure"`) != 2 {
+		t.Errorf("TestRenewFailure was incorrect, failures not audited: <%s>.", raw)
+	}
+}
+
+func TestRenewRollback(t *testing.T) {
+	r, pki, dir := testRenewer(t, 10)
+	defer os.RemoveAll(dir)
+	defer r.audit.Close()
+	certFile := filepath.Join(dir, "cert", "ezb_vault.crt")
+	old, _ := ioutil.ReadFile(certFile)
+	before := r.certs.Leaf().NotAfter
+	// the new pair pass the check at r.now but not the load, at time.Now
+	pki.delay = 48 * time.Hour
+	r.now = func() time.Time { return time.Now().Add(72 * time.Hour) }
+	r.Check()
+	if got := r.certs.Leaf().NotAfter; !got.Equal(before) {
+		t.Errorf("TestRenewRollback was incorrect, got: <%s>, want: <%s>.", got, before)
+	}
+	if raw, _ := ioutil.ReadFile(certFile); string(raw) != string(old) {
+		t.Errorf("TestRenewRollback was incorrect, the old certificate was not put back.")
+	}
+	for _, f := range []string{"ezb_vault.crt.old", "ezb_vault.key.old", "ca.crt", "ezb_vault.crt.new", "ca.crt.new"} {
+		if _, err := os.Stat(filepath.Join(dir, "cert", f)); err == nil {
+			t.Errorf("TestRenewRollback was incorrect, %s left.", f)
+		}
+	}
+	// the served pair still load
+	if err := r.certs.Load(r.live.Get(), dir); err != nil {
+		t.Errorf("TestRenewRollback was incorrect, got: <%v>, want: <nil>.", err)
+	}
+	raw, _ := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	if !strings.Contains(string(raw), `"result":"failure"`) {
+		t.Errorf("TestRenewRollback was incorrect, failure not audited: <%s>.", raw)
+	}
+}
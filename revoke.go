@@ -0,0 +1,106 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ezbastion/ezb_vault/Middleware"
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/ezbastion/ezb_vault/models"
+	"github.com/urfave/cli"
+)
+
+// revokeCommand manage the revocation list from the console. The running
+// service pick up the changes on its next tick.
+func revokeCommand() cli.Command {
+	return cli.Command{
+		Name:  "revoke",
+		Usage: "Manage revoked tokens and subjects.",
+		Before: func(c *cli.Context) error {
+			if firstcall {
+				return fmt.Errorf("ezb_vault not initialized")
+			}
+			return nil
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:  "add",
+				Usage: "Revoke a token (--jti) or every token of a subject (--sub).",
+				Flags: []cli.Flag{
+					cli.StringFlag{Name: "jti", Usage: "token id"},
+					cli.StringFlag{Name: "sub", Usage: "token subject"},
+					cli.StringFlag{Name: "reason", Usage: "free text"},
+				},
+				Action: func(c *cli.Context) error {
+					db, err := configuration.InitDB(conf, exPath)
+					if err != nil {
+						return err
+					}
+					defer db.Close()
+					rl, err := Middleware.NewRevocationList(db)
+					if err != nil {
+						return err
+					}
+					r := models.Revocation{JTI: c.String("jti"), SUB: c.String("sub"), Reason: c.String("reason")}
+					if err := rl.Revoke(&r); err != nil {
+						return err
+					}
+					fmt.Printf("revocation #%d added\n", r.ID)
+					return nil
+				},
+			}, {
+				Name:  "list",
+				Usage: "List revocations.",
+				Action: func(c *cli.Context) error {
+					db, err := configuration.InitDB(conf, exPath)
+					if err != nil {
+						return err
+					}
+					defer db.Close()
+					var revs []models.Revocation
+					if err := db.Find(&revs).Error; err != nil {
+						return err
+					}
+					for _, r := range revs {
+						fmt.Printf("#%d\tjti:%s\tsub:%s\t%s\t%s\n", r.ID, r.JTI, r.SUB, r.CreatedAt.Format("2006-01-02 15:04:05"), r.Reason)
+					}
+					return nil
+				},
+			}, {
+				Name:      "remove",
+				Usage:     "Remove a revocation by id.",
+				ArgsUsage: "<id>",
+				Action: func(c *cli.Context) error {
+					db, err := configuration.InitDB(conf, exPath)
+					if err != nil {
+						return err
+					}
+					defer db.Close()
+					rl, err := Middleware.NewRevocationList(db)
+					if err != nil {
+						return err
+					}
+					var id int
+					if _, err := fmt.Sscan(c.Args().First(), &id); err != nil {
+						return fmt.Errorf("bad revocation id: %s", c.Args().First())
+					}
+					return rl.Remove(id)
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,123 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/ezbastion/ezb_vault/models"
+)
+
+func TestAdminRefused(t *testing.T) {
+	_, login := testVault(t, configuration.Configuration{Admins: []string{"root"}})
+	bob := login("bob", "bobkey")
+	for _, r := range []struct{ method, path string }{
+		{"GET", "/sys/users"},
+		{"DELETE", "/sys/users/alice"},
+		{"POST", "/sys/users/alice/keys/db-pass/lock"},
+		{"GET", "/sys/revocations"},
+	} {
+		if w := bob(r.method, r.path, ""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "#V0016") {
+			t.Errorf("TestAdminRefused %s %s was incorrect, got: <%d %s>, want: <%d #V0016>.", r.method, r.path, w.Code, w.Body, http.StatusForbidden)
+		}
+	}
+}
+
+func TestAdminKeyState(t *testing.T) {
+	_, login := testVault(t, configuration.Configuration{Admins: []string{"root"}})
+	alice, root := login("alice", "alicekey"), login("root", "rootkey")
+	if w := alice("POST", "/", `{"key":"db-pass","value":"s3cret"}`); w.Code != http.StatusCreated {
+		t.Fatalf("TestAdminKeyState create was incorrect, got: <%d %s>, want: <%d>.", w.Code, w.Body, http.StatusCreated)
+	}
+	tests := []struct {
+		action string
+		code   int
+		body   string
+	}{
+		{"lock", http.StatusLocked, "#V0018"},
+		{"unlock", http.StatusOK, "s3cret"},
+		{"expire", http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		if w := root("POST", "/sys/users/alice/keys/db-pass/"+tt.action, ""); w.Code != http.StatusOK {
+			t.Fatalf("TestAdminKeyState %s was incorrect, got: <%d %s>, want: <%d>.", tt.action, w.Code, w.Body, http.StatusOK)
+		}
+		if w := alice("GET", "/db-pass", ""); w.Code != tt.code || !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("TestAdminKeyState get after %s was incorrect, got: <%d %s>, want: <%d %s>.", tt.action, w.Code, w.Body, tt.code, tt.body)
+		}
+	}
+	// a locked secret can not be changed by its owner
+	root("POST", "/sys/users/alice/keys/db-pass/lock", "")
+	if w := alice("PUT", "/db-pass", `{"value":"n3w"}`); w.Code != http.StatusLocked {
+		t.Errorf("TestAdminKeyState update locked was incorrect, got: <%d>, want: <%d>.", w.Code, http.StatusLocked)
+	}
+	if w := alice("DELETE", "/db-pass", ""); w.Code != http.StatusLocked {
+		t.Errorf("TestAdminKeyState delete locked was incorrect, got: <%d>, want: <%d>.", w.Code, http.StatusLocked)
+	}
+}
+
+func TestAdminDeleteUser(t *testing.T) {
+	db, login := testVault(t, configuration.Configuration{Admins: []string{"root"}})
+	alice, root := login("alice", "alicekey"), login("root", "rootkey")
+	alice("POST", "/", `{"key":"db-pass","value":"s3cret"}`)
+	alice("PUT", "/db-pass", `{"value":"n3w"}`)
+	if w := alice("POST", "/db-pass/shares", `{"grantee":"bob","passphrase":"bobshare"}`); w.Code != http.StatusCreated {
+		t.Fatalf("TestAdminDeleteUser share was incorrect, got: <%d %s>, want: <%d>.", w.Code, w.Body, http.StatusCreated)
+	}
+	login("bob", "bobkey")("POST", "/", `{"key":"bob-pass","value":"mine"}`)
+	if w := root("DELETE", "/sys/users/alice", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("TestAdminDeleteUser was incorrect, got: <%d %s>, want: <%d>.", w.Code, w.Body, http.StatusNoContent)
+	}
+	var keys, shares, versions int
+	db.Model(&models.KeyVal{}).Count(&keys)
+	db.Model(&models.Share{}).Count(&shares)
+	db.Model(&models.Version{}).Count(&versions)
+	if keys != 1 || shares != 0 || versions != 0 {
+		t.Errorf("TestAdminDeleteUser was incorrect, got: <%d keys %d shares %d versions>, want: <1 0 0>.", keys, shares, versions)
+	}
+}
+
+// the counts of /sys/status are of the vault for an administrator, of the
+// caller secrets for the others.
+func TestStatus(t *testing.T) {
+	_, login := testVault(t, configuration.Configuration{Admins: []string{"root"}})
+	alice, bob, root := login("alice", "alicekey"), login("bob", "bobkey"), login("root", "rootkey")
+	alice("POST", "/", `{"key":"a1","value":"v"}`)
+	alice("POST", "/", `{"key":"a2","value":"v"}`)
+	alice("POST", "/a1/shares", `{"grantee":"bob","passphrase":"bobshare"}`)
+	bob("POST", "/", `{"key":"b1","value":"v"}`)
+	root("POST", "/sys/users/alice/keys/a2/lock", "")
+	tests := []struct {
+		who  request
+		want models.Status
+	}{
+		{alice, models.Status{Scope: "own", Keys: 2, Locked: 1, Shares: 1}},
+		{bob, models.Status{Scope: "own", Keys: 1}},
+		{root, models.Status{Scope: "vault", Users: 2, Keys: 3, Locked: 1, Shares: 1}},
+	}
+	for i, tt := range tests {
+		w := tt.who("GET", "/sys/status", "")
+		var got models.Status
+		json.Unmarshal(w.Body.Bytes(), &got)
+		if w.Code != http.StatusOK || got.Scope != tt.want.Scope || got.Users != tt.want.Users || got.Keys != tt.want.Keys || got.Locked != tt.want.Locked || got.Shares != tt.want.Shares {
+			t.Errorf("TestStatus #%d was incorrect, got: <%d %s>, want: <%+v>.", i, w.Code, w.Body, tt.want)
+		}
+	}
+}
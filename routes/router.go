@@ -0,0 +1,66 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package routes
+
+import (
+	"github.com/ezbastion/ezb_vault/Middleware"
+	"github.com/ezbastion/ezb_vault/audit"
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/ezbastion/ezb_vault/ctrl"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// Vault is what the router need, built by MainGin or by the tests.
+type Vault struct {
+	Live       *configuration.Live
+	DB         *gorm.DB
+	Audit      *audit.Logger
+	Guard      *Middleware.Guard
+	Revocation *Middleware.RevocationList
+	Policies   *Middleware.PolicyStore
+	Keys       *Middleware.IssuerKeys
+	Server     *ctrl.Server
+}
+
+// New build the engine with the middlewares in their order, the probes, the
+// metrics and the routes. first are set before all, like the request logger.
+func New(v Vault, first ...gin.HandlerFunc) *gin.Engine {
+	conf := v.Live.Get()
+	r := gin.New()
+	// a key may hold a /, the kv commands send it escaped as %2F
+	r.UseRawPath = true
+	// no proxy is trusted, ClientIP is the peer like the audit and the limits
+	r.SetTrustedProxies(nil)
+	r.Use(first...)
+	r.Use(Middleware.ErrorBody)
+	r.Use(Middleware.CORS(v.Live))
+	r.Use(Middleware.SecurityHeaders)
+	r.Use(Middleware.BodyLimit(v.Live))
+	Probes(r, v.Server)
+	Metrics(r, conf)
+	r.Use(Middleware.MetricsMiddleware)
+	r.Use(Middleware.AuditMiddleware(v.Audit))
+	r.Use(v.Guard.IPLimit())
+	r.Use(Middleware.AuthJWT(v.DB, v.Live, v.Revocation, v.Keys))
+	r.Use(v.Guard.SubjectLimit())
+	r.Use(Middleware.DBMiddleware(v.DB))
+	r.Use(Middleware.ConfMiddleware(v.Live))
+	r.Use(Middleware.PolicyMiddleware(v.Policies))
+	r.Use(Middleware.RevocationMiddleware(v.Revocation))
+	Routes(r, v.Live, v.Server)
+	return r
+}
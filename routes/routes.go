@@ -16,19 +16,65 @@
 package routes
 
 import (
+	"github.com/ezbastion/ezb_vault/Middleware"
+	"github.com/ezbastion/ezb_vault/configuration"
 	"github.com/ezbastion/ezb_vault/ctrl"
+	"github.com/ezbastion/ezb_vault/metrics"
 
 	"github.com/gin-gonic/gin"
 )
 
-func Routes(route *gin.Engine) {
+// Probes register the unauthenticated health and readiness routes, before
+// the AuthJWT middleware.
+func Probes(route *gin.Engine, srv *ctrl.Server) {
+	route.GET("/sys/health", srv.Health)
+	route.GET("/sys/ready", srv.Ready)
+}
+
+// Metrics register /sys/metrics, without token, unless disabled. Under /sys,
+// a secret may be named metrics.
+func Metrics(route *gin.Engine, conf configuration.Configuration) {
+	if conf.Metrics.Disabled {
+		return
+	}
+	route.GET("/sys/metrics", Middleware.MetricsAllow(conf.Metrics.Allow), gin.WrapH(metrics.Handler()))
+}
 
-	KV := route.Group("")
+func Routes(route *gin.Engine, live *configuration.Live, srv *ctrl.Server) {
+	KV := route.Group("", Middleware.ACL)
 	{
 		KV.GET("/", ctrl.GetAll)
 		KV.GET("/:name", ctrl.GetVal)
 		KV.POST("/", ctrl.AddVal)
 		KV.PUT("/:name", ctrl.UpdateVal)
 		KV.DELETE("/:name", ctrl.DeleteVal)
+		KV.GET("/:name/versions", ctrl.GetVersions)
+		KV.GET("/:name/shares", ctrl.GetShares)
+		KV.POST("/:name/shares", ctrl.AddShare)
+		KV.DELETE("/:name/shares/:id", ctrl.DeleteShare)
+		// under /sys, a secret may be named export or import
+		KV.GET("/sys/export", ctrl.Export)
+		KV.POST("/sys/import", ctrl.Import)
+	}
+	route.GET("/sys/status", srv.Status)
+	SYS := route.Group("/sys", Middleware.Admin(live))
+	{
+		SYS.GET("/policies", ctrl.GetPolicies)
+		SYS.POST("/policies", ctrl.AddPolicy)
+		SYS.PUT("/policies/:id", ctrl.UpdatePolicy)
+		SYS.DELETE("/policies/:id", ctrl.DeletePolicy)
+		SYS.GET("/users", ctrl.GetUsers)
+		SYS.DELETE("/users/:user", ctrl.DeleteUser)
+		SYS.GET("/users/:user/keys", ctrl.GetUserKeys)
+		SYS.GET("/users/:user/keys/:name", ctrl.GetUserKey)
+		SYS.POST("/users/:user/keys/:name/lock", ctrl.LockKey)
+		SYS.POST("/users/:user/keys/:name/unlock", ctrl.UnlockKey)
+		SYS.POST("/users/:user/keys/:name/expire", ctrl.ExpireKey)
+		SYS.GET("/revocations", ctrl.GetRevocations)
+		SYS.POST("/revocations", ctrl.AddRevocation)
+		SYS.DELETE("/revocations/:id", ctrl.DeleteRevocation)
+		SYS.POST("/reload", ctrl.Reload)
+		SYS.GET("/lockouts", ctrl.GetLockouts)
+		SYS.DELETE("/lockouts/:key", ctrl.DeleteLockout)
 	}
 }
@@ -0,0 +1,188 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package routes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/ezbastion/ezb_vault/Middleware"
+	"github.com/ezbastion/ezb_vault/audit"
+	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/ezbastion/ezb_vault/ctrl"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// request run one request of a subject, with its EZB-VAULT-KEY.
+type request func(method, path, body string) *httptest.ResponseRecorder
+
+// vault build the router over a new database, and return a function running
+// one request as alice.
+func vault(t *testing.T, conf configuration.Configuration) request {
+	_, login := testVault(t, conf)
+	return login("alice", "alicekey")
+}
+
+// testVault build the router over a new database, and return the database and
+// a function logging in a subject with its key.
+func testVault(t *testing.T, conf configuration.Configuration) (*gorm.DB, func(sub, key string) request) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "cert"), 0700)
+	sta, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pub, _ := x509.MarshalPKIXPublicKey(&sta.PublicKey)
+	ioutil.WriteFile(filepath.Join(dir, "cert", "ezb_sta.crt"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0600)
+	conf.DB = "test.db"
+	db, err := configuration.InitDB(conf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	al, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		al.Close()
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	live := configuration.NewLive(conf, dir)
+	rl, _ := Middleware.NewRevocationList(db)
+	ps, _ := Middleware.NewPolicyStore(db)
+	gin.SetMode(gin.ReleaseMode)
+	r := New(Vault{
+		Live:       live,
+		DB:         db,
+		Audit:      al,
+		Guard:      Middleware.NewGuard(live, al),
+		Revocation: rl,
+		Policies:   ps,
+		Keys:       Middleware.NewIssuerKeys(filepath.Join(dir, "cert")),
+		Server:     &ctrl.Server{},
+	})
+	return db, func(sub, key string) request {
+		return func(method, path, body string) *httptest.ResponseRecorder {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+				"iss": "ezb_sta",
+				"sub": sub,
+				"jti": fmt.Sprint(time.Now().UnixNano()),
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}).SignedString(sta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("EZB-VAULT-KEY", key)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			return w
+		}
+	}
+}
+
+// A key may hold a /, the kv commands send it escaped.
+func TestSlashKey(t *testing.T) {
+	do := vault(t, configuration.Configuration{})
+	if w := do("POST", "/", `{"key":"prod/sql","value":"first"}`); w.Code != http.StatusCreated {
+		t.Fatalf("TestSlashKey create was incorrect, got: <%d %s>, want: <%d>.", w.Code, w.Body, http.StatusCreated)
+	}
+	if w := do("PUT", "/prod%2Fsql", `{"value":"second"}`); w.Code != http.StatusOK {
+		t.Errorf("TestSlashKey update was incorrect, got: <%d %s>, want: <%d>.", w.Code, w.Body, http.StatusOK)
+	}
+	if w := do("GET", "/prod%2Fsql", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"second"`) {
+		t.Errorf("TestSlashKey get was incorrect, got: <%d %s>, want: <%d second>.", w.Code, w.Body, http.StatusOK)
+	}
+	if w := do("GET", "/prod/sql", ""); w.Code != http.StatusNotFound {
+		t.Errorf("TestSlashKey unescaped was incorrect, got: <%d>, want: <%d>.", w.Code, http.StatusNotFound)
+	}
+	if w := do("DELETE", "/prod%2Fsql", ""); w.Code != http.StatusNoContent {
+		t.Errorf("TestSlashKey delete was incorrect, got: <%d %s>, want: <%d>.", w.Code, w.Body, http.StatusNoContent)
+	}
+}
+
+// export and import are mounted under /sys, secrets may have these names.
+func TestBundleRoutes(t *testing.T) {
+	do := vault(t, configuration.Configuration{})
+	for _, key := range []string{"export", "import"} {
+		if w := do("POST", "/", `{"key":"`+key+`","value":"v"}`); w.Code != http.StatusCreated {
+			t.Fatalf("TestBundleRoutes create %s was incorrect, got: <%d %s>, want: <%d>.", key, w.Code, w.Body, http.StatusCreated)
+		}
+		if w := do("GET", "/"+key, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"`+key+`"`) {
+			t.Errorf("TestBundleRoutes get %s was incorrect, got: <%d %s>, want: <%d>.", key, w.Code, w.Body, http.StatusOK)
+		}
+	}
+	// without EZB-VAULT-BUNDLE-KEY
+	if w := do("GET", "/sys/export", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("TestBundleRoutes export was incorrect, got: <%d %s>, want: <%d>.", w.Code, w.Body, http.StatusBadRequest)
+	}
+}
+
+// /sys/metrics is served without token, a secret may be named metrics.
+func TestMetricsRoute(t *testing.T) {
+	do := vault(t, configuration.Configuration{})
+	if w := do("POST", "/", `{"key":"metrics","value":"v"}`); w.Code != http.StatusCreated {
+		t.Fatalf("TestMetricsRoute create was incorrect, got: <%d %s>, want: <%d>.", w.Code, w.Body, http.StatusCreated)
+	}
+	if w := do("GET", "/metrics", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"key":"metrics"`) {
+		t.Errorf("TestMetricsRoute secret was incorrect, got: <%d %s>, want: <%d secret>.", w.Code, w.Body, http.StatusOK)
+	}
+	if w := do("GET", "/sys/metrics", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "ezb_vault_http_requests_total") {
+		t.Errorf("TestMetricsRoute was incorrect, got: <%d>, want: <%d prometheus>.", w.Code, http.StatusOK)
+	}
+}
+
+// a body over maxbody is refused by BodyLimit, a value over maxvalue by the
+// key/value handlers.
+func TestSizeLimits(t *testing.T) {
+	do := vault(t, configuration.Configuration{MaxBody: 256, MaxValue: 16})
+	if w := do("POST", "/", `{"key":"k","value":"small"}`); w.Code != http.StatusCreated {
+		t.Fatalf("TestSizeLimits create was incorrect, got: <%d %s>, want: <%d>.", w.Code, w.Body, http.StatusCreated)
+	}
+	big := strings.Repeat("x", 17)
+	for _, tt := range []struct {
+		method, path, body string
+		code               string
+	}{
+		{"POST", "/", `{"key":"big","value":"` + big + `"}`, "#V0024"},
+		{"PUT", "/k", `{"value":"` + big + `"}`, "#V0024"},
+		{"POST", "/", `{"key":"huge","value":"` + strings.Repeat("x", 300) + `"}`, "#V0023"},
+	} {
+		if w := do(tt.method, tt.path, tt.body); w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), tt.code) {
+			t.Errorf("TestSizeLimits %s %s was incorrect, got: <%d %s>, want: <%d %s>.", tt.method, tt.path, w.Code, w.Body, http.StatusRequestEntityTooLarge, tt.code)
+		}
+	}
+	if w := do("GET", "/k", ""); !strings.Contains(w.Body.String(), `"small"`) {
+		t.Errorf("TestSizeLimits was incorrect, got: <%s>, want: <small>.", w.Body)
+	}
+}
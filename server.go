@@ -17,111 +17,157 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"net/http"
 	"os"
-	"os/signal"
 	"path"
 	"path/filepath"
 	"time"
 
-	ezbevent "github.com/ezbastion/ezb_lib/eventlogmanager"
 	"github.com/ezbastion/ezb_lib/logmanager"
 	"github.com/ezbastion/ezb_vault/Middleware"
+	"github.com/ezbastion/ezb_vault/audit"
 	"github.com/ezbastion/ezb_vault/configuration"
+	"github.com/ezbastion/ezb_vault/ctrl"
+	"github.com/ezbastion/ezb_vault/metrics"
 	"github.com/ezbastion/ezb_vault/routes"
 	"github.com/gin-gonic/contrib/ginrus"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/sys/windows/svc"
-	"golang.org/x/sys/windows/svc/debug"
 )
 
 var defaultconflisten string
 var err error
 
-type myservice struct{}
-
-func (m *myservice) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
-	logmanager.Debug("#### EXECUTE started #####")
-	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
-	changes <- svc.Status{State: svc.StartPending}
-	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
-	serverchan := make(chan bool)
-	go MainGin(&serverchan)
-loop:
-	for {
-		select {
-		case c := <-r:
-			switch c.Cmd {
-			case svc.Interrogate:
-				changes <- c.CurrentStatus
-				time.Sleep(100 * time.Millisecond)
-				changes <- c.CurrentStatus
-			case svc.Stop, svc.Shutdown:
-				close(serverchan)
-				break loop
-			default:
-				logmanager.Error(fmt.Sprintf("unexpected control request #%d", c))
-			}
-		}
-	}
-	changes <- svc.Status{State: svc.StopPending}
-	return
+// MainGin starts the server and block until ctx is done, then drain the
+// requests in flight and stop the background jobs. Startup and listen errors
+// are returned. A value on reload read config.json and the certificates again.
+func MainGin(ctx context.Context, reload <-chan struct{}) error {
+	ex, _ := os.Executable()
+	return serve(ctx, filepath.Dir(ex), reload)
 }
 
-// RunService runs the service targeted by name. From 06/27/2019, debug is not needed as the debug is always done, log system will
-// handle th level
-func RunService(name string, isdebug bool) {
-
-	defer ezbevent.Close()
+// serve run the server of exPath. The jobs are stopped first, then the audit
+// log and the database are closed, on a startup error too.
+func serve(ctx context.Context, exPath string, reload <-chan struct{}) error {
+	conf, err := configuration.CheckConfig(false, exPath)
+	if err != nil {
+		return fmt.Errorf("Error during reading Configuration : %s", err.Error())
+	}
+	if err := conf.Validate(); err != nil {
+		return fmt.Errorf("Invalid configuration :\n%s", err.Error())
+	}
+	jobs := newLifecycle(ctx)
+	defer jobs.Stop()
 
-	run := svc.Run
-	if isdebug {
-		run = debug.Run
+	db, err := configuration.InitDB(conf, exPath)
+	if err != nil {
+		return fmt.Errorf("Error during InitDB Configuration : %s", err.Error())
 	}
+	jobs.Defer("database", db.Close)
 
-	logmanager.Info(fmt.Sprintf("starting the %s service", name))
-	err = run(name, &myservice{})
+	rl, err := Middleware.NewRevocationList(db)
 	if err != nil {
-		logmanager.Error(fmt.Sprintf("%s service failed: %s", name, err.Error()))
-		return
+		return fmt.Errorf("Error during loading revocation list : %s", err.Error())
+	}
+	ps, err := Middleware.NewPolicyStore(db)
+	if err != nil {
+		return fmt.Errorf("Error during loading policies : %s", err.Error())
 	}
-	logmanager.Info(fmt.Sprintf("%s service stopped", name))
-}
 
-// MainGin starts the server
-func MainGin(serverchan *chan bool) {
-	ti := time.NewTicker(1 * time.Minute)
-	defer ti.Stop()
-	ex, _ := os.Executable()
-	exPath := filepath.Dir(ex)
-	conf, err := configuration.CheckConfig(false, exPath)
+	certs := &certStore{}
+	if err := certs.Load(conf, exPath); err != nil {
+		return fmt.Errorf("Error during loading certificate : %s", err.Error())
+	}
+	tlsConfig, err := conf.TLSConfig(exPath)
 	if err != nil {
-		logmanager.Fatal(fmt.Sprintf("Error during reading Configuration : %s", err.Error()))
-		panic(err)
+		return fmt.Errorf("Error in tls configuration : %s", err.Error())
 	}
+	tlsConfig.GetCertificate = certs.GetCertificate
+	keys := Middleware.NewIssuerKeys(path.Join(exPath, "cert"))
 
-	db, err := configuration.InitDB(conf, exPath)
+	// listen, dbpath, audit and tls options are only read at start.
+	live := configuration.NewLive(conf, exPath)
+	live.OnReload(func(c configuration.Configuration) error {
+		return certs.Load(c, exPath)
+	})
+	live.OnReload(func(c configuration.Configuration) error {
+		keys.Reset()
+		setLogLevel(c)
+		return nil
+	})
+
+	al, err := audit.FromConf(conf, exPath)
 	if err != nil {
-		logmanager.Fatal(fmt.Sprintf("Error during InitDB Configuration : %s", err.Error()))
-		panic(err)
+		return fmt.Errorf("Error during opening audit log : %s", err.Error())
 	}
+	jobs.Defer("audit log", al.Close)
 
-	// Init of the GIN Web HTTP framework
-	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
-	r.Use(ginrus.Ginrus(log.StandardLogger(), time.RFC3339, true))
-	r.Use(Middleware.AddHeaders)
-	r.Use(Middleware.AuthJWT(db, conf))
-	r.OPTIONS("*a", func(c *gin.Context) {
-		c.AbortWithStatus(200)
+	jobs.Every("reload", 1*time.Minute, func() {
+		if err := ps.Load(); err != nil {
+			logmanager.Error(fmt.Sprintf("Error during reloading policies : %s", err.Error()))
+		}
+		if err := rl.Load(); err != nil {
+			logmanager.Error(fmt.Sprintf("Error during reloading revocation list : %s", err.Error()))
+		}
+		if err := rl.Purge(); err != nil {
+			logmanager.Error(fmt.Sprintf("Error during purging used tokens : %s", err.Error()))
+		}
+	})
+	if conf.TLS.OCSPStaple != "" {
+		jobs.Every("ocsp", 1*time.Hour, certs.RefreshStaple)
+	}
+	renew := &renewer{certs: certs, live: live, exPath: exPath, issue: pkiIssuer, audit: al, now: time.Now}
+	jobs.Go("renew", func(context.Context) { renew.Check() })
+	jobs.Every("renew", 1*time.Hour, renew.Check)
+	jobs.Go("reload", func(ctx context.Context) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reload:
+				if err := live.Reload(); err != nil {
+					logmanager.Error(fmt.Sprintf("Error during reloading configuration : %s", err.Error()))
+					continue
+				}
+				logmanager.Info("configuration reloaded")
+			}
+		}
 	})
-	r.Use(Middleware.DBMiddleware(db))
-	routes.Routes(r)
 
-	tlsConfig := &tls.Config{}
+	srv := &ctrl.Server{
+		Version: version,
+		Started: time.Now(),
+		Storage: "sqlite3:" + conf.DB,
+		Checks: map[string]func() error{
+			"database": func() error { return db.DB().Ping() },
+			"certificate": func() error {
+				if leaf := certs.Leaf(); leaf != nil {
+					return checkCert(leaf, live.Get().SAN, time.Now())
+				}
+				return fmt.Errorf("no certificate")
+			},
+		},
+		Cert: certs.Leaf,
+	}
+	metrics.InstrumentDB(db)
+	metrics.Secrets(db, "sqlite3")
+	metrics.CertExpiry(certs.Leaf)
+	guard := Middleware.NewGuard(live, al)
+	jobs.Every("guard", 1*time.Minute, guard.Purge)
+
+	// Init of the GIN Web HTTP framework
+	gin.SetMode(gin.ReleaseMode)
+	r := routes.New(routes.Vault{
+		Live:       live,
+		DB:         db,
+		Audit:      al,
+		Guard:      guard,
+		Revocation: rl,
+		Policies:   ps,
+		Keys:       keys,
+		Server:     srv,
+	}, gin.Logger(), gin.Recovery(), ginrus.Ginrus(log.StandardLogger(), time.RFC3339, true))
 
 	server := &http.Server{
 		Addr:      conf.Listen,
@@ -130,20 +176,23 @@ func MainGin(serverchan *chan bool) {
 	}
 
 	logmanager.Info("Server EZB_VAULT started")
+	listen := make(chan error, 1)
 	go func() {
-		if err := server.ListenAndServeTLS(path.Join(exPath, conf.PublicCert), path.Join(exPath, conf.PrivateKey)); err != nil {
-			logmanager.Error(fmt.Sprintf("listen: %s", err))
-		}
+		listen <- server.ListenAndServeTLS("", "")
 	}()
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+	select {
+	case err := <-listen:
+		return fmt.Errorf("listen: %s", err)
+	case <-ctx.Done():
+	}
 
 	logmanager.Info("Shutdown Server ...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	sctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownWait())
 	defer cancel()
-	if err = server.Shutdown(ctx); err != nil {
-		logmanager.Fatal(fmt.Sprintf("Reero during Server Shutdown : %s", err.Error()))
+	if err := server.Shutdown(sctx); err != nil {
+		logmanager.Error(fmt.Sprintf("Error during Server Shutdown : %s", err.Error()))
+		server.Close()
 	}
 	logmanager.Info("Server exited")
+	return nil
 }
@@ -0,0 +1,186 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/template"
+
+	"github.com/ezbastion/ezb_lib/logmanager"
+	"github.com/ezbastion/ezb_vault/configuration"
+)
+
+// unitDir is where install write the systemd unit.
+var unitDir = "/etc/systemd/system"
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description={{.Description}}
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+{{if .Config}}Environment=EZB_VAULT_CONFIG={{.Config}}
+{{end}}ExecStart={{.Exec}} run
+ExecReload=/bin/kill -HUP $MAINPID
+WorkingDirectory={{.Dir}}
+Restart=on-failure
+RestartSec=5
+NoNewPrivileges=true
+PrivateTmp=true
+ProtectSystem=full
+ProtectHome=true
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// RunService runs the vault in the foreground until SIGINT or SIGTERM, systemd
+// start it with the run command. SIGHUP reload the configuration. A startup
+// failure exit with status 1.
+func RunService(name string, isdebug bool) {
+	logmanager.Info(fmt.Sprintf("starting the %s service", name))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(quit)
+	reload := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case sig := <-quit:
+				if sig != syscall.SIGHUP {
+					cancel()
+					return
+				}
+				select {
+				case reload <- struct{}{}:
+				default:
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	if err := MainGin(ctx, reload); err != nil {
+		logmanager.Error(fmt.Sprintf("%s service failed: %s", name, err.Error()))
+		os.Exit(1)
+	}
+	logmanager.Info(fmt.Sprintf("%s service stopped", name))
+}
+
+// RunForeground runs the vault in the console or in a container.
+func RunForeground(name string) {
+	RunService(name, false)
+}
+
+// isInteractive is always true, the service is started with the run command.
+func isInteractive() bool {
+	return true
+}
+
+func startEventLog(name string) {}
+
+func unitFile(name string) string {
+	return filepath.Join(unitDir, name+".service")
+}
+
+// systemUnit return the unit running this executable from its folder.
+func systemUnit(fullName string) ([]byte, error) {
+	ex, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if ex, err = filepath.EvalSymlinks(ex); err != nil {
+		return nil, err
+	}
+	if fullName == "" {
+		fullName = "ezBastion key/value vault"
+	}
+	// a --config file is kept for the service
+	config := configuration.ConfFile(exPath)
+	if config == path.Join(exPath, "conf/config.json") {
+		config = ""
+	}
+	var b bytes.Buffer
+	err = unitTemplate.Execute(&b, struct{ Description, Exec, Dir, Config string }{fullName, ex, filepath.Dir(ex), config})
+	return b.Bytes(), err
+}
+
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %v %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func installService(name, fullName string) error {
+	if _, err := os.Stat(unitFile(name)); err == nil {
+		return fmt.Errorf("service %s already exists", name)
+	}
+	unit, err := systemUnit(fullName)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(unitFile(name), unit, 0644); err != nil {
+		return err
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", name+".service")
+}
+
+func removeService(name string) error {
+	if _, err := os.Stat(unitFile(name)); err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	systemctl("stop", name+".service")
+	if err := systemctl("disable", name+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(unitFile(name)); err != nil {
+		return err
+	}
+	return systemctl("daemon-reload")
+}
+
+func startService(name string) error {
+	return systemctl("start", name+".service")
+}
+
+func stopService(name string) error {
+	return systemctl("stop", name+".service")
+}
+
+func reloadService(name string) error {
+	return systemctl("reload", name+".service")
+}
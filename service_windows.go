@@ -0,0 +1,136 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ezbevent "github.com/ezbastion/ezb_lib/eventlogmanager"
+	"github.com/ezbastion/ezb_lib/logmanager"
+	"github.com/ezbastion/ezb_lib/servicemanager"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/debug"
+)
+
+type myservice struct{}
+
+func (m *myservice) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
+	logmanager.Debug("#### EXECUTE started #####")
+	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+	changes <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	reload := make(chan struct{}, 1)
+	go func() {
+		done <- MainGin(ctx, reload)
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+loop:
+	for {
+		select {
+		case err := <-done:
+			// the server stopped by itself, startup or listen failure
+			logmanager.Error(err.Error())
+			changes <- svc.Status{State: svc.StopPending}
+			return true, 1
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+				time.Sleep(100 * time.Millisecond)
+				changes <- c.CurrentStatus
+			case svc.ParamChange:
+				select {
+				case reload <- struct{}{}:
+				default:
+				}
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				break loop
+			default:
+				logmanager.Error(fmt.Sprintf("unexpected control request #%d", c))
+			}
+		}
+	}
+	changes <- svc.Status{State: svc.StopPending}
+	cancel()
+	if err := <-done; err != nil {
+		logmanager.Error(err.Error())
+		return true, 1
+	}
+	return
+}
+
+// RunService runs the service targeted by name. From 06/27/2019, debug is not needed as the debug is always done, log system will
+// handle th level
+func RunService(name string, isdebug bool) {
+
+	defer ezbevent.Close()
+
+	run := svc.Run
+	if isdebug {
+		run = debug.Run
+	}
+
+	logmanager.Info(fmt.Sprintf("starting the %s service", name))
+	err = run(name, &myservice{})
+	if err != nil {
+		logmanager.Error(fmt.Sprintf("%s service failed: %s", name, err.Error()))
+		return
+	}
+	logmanager.Info(fmt.Sprintf("%s service stopped", name))
+}
+
+// RunForeground runs the service in the console, like debug.
+func RunForeground(name string) {
+	RunService(name, true)
+}
+
+func isInteractive() bool {
+	isIntSess, _ := svc.IsAnInteractiveSession()
+	return isIntSess
+}
+
+func startEventLog(name string) {
+	logmanager.StartWindowsEvent(name)
+}
+
+func installService(name, fullName string) error {
+	return servicemanager.InstallService(name, fullName)
+}
+
+func removeService(name string) error {
+	return servicemanager.RemoveService(name)
+}
+
+func startService(name string) error {
+	return servicemanager.StartService(name)
+}
+
+func stopService(name string) error {
+	return servicemanager.ControlService(name, svc.Stop, svc.Stopped)
+}
+
+// reloadService send a paramchange control, the service reload its configuration.
+func reloadService(name string) error {
+	return servicemanager.ControlService(name, svc.ParamChange, svc.Running)
+}
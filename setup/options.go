@@ -0,0 +1,174 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package setup
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ezbastion/ezb_vault/configuration"
+)
+
+// Exit codes of a failed init.
+const (
+	ExitAnswers = 2 // an answer or the answers file is wrong
+	ExitPKI     = 3 // ezb_pki unreachable or the certificate not issued
+	ExitCert    = 4 // supplied certificates missing or unusable
+	ExitWrite   = 5 // a folder, a certificate or config.json not written
+)
+
+// Error is an init failure and its exit code.
+type Error struct {
+	Code int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func fail(code int, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Err: fmt.Errorf(format, a...)}
+}
+
+// Options are the init answers, from the command line or an answers file. A
+// blank answer keep the default. With NonInteractive nothing is asked.
+// Offline skip ezb_pki: the certificates must be given by Cert, Key and CA or
+// already be in place, or SelfSigned generate a development certificate.
+type Options struct {
+	NonInteractive  bool     `json:"-"`
+	Offline         bool     `json:"offline,omitempty"`
+	SelfSigned      bool     `json:"selfsigned,omitempty"`
+	EzbPki          string   `json:"ezb_pki,omitempty"`
+	SAN             []string `json:"san,omitempty"`
+	Listen          string   `json:"listen,omitempty"`
+	ServiceName     string   `json:"servicename,omitempty"`
+	ServiceFullName string   `json:"servicefullname,omitempty"`
+	LogLevel        string   `json:"loglevel,omitempty"`
+	DB              string   `json:"dbpath,omitempty"`
+	Cert            string   `json:"cert,omitempty"`
+	Key             string   `json:"key,omitempty"`
+	CA              string   `json:"ca,omitempty"`
+	Sta             []string `json:"sta,omitempty"`
+}
+
+// apply set the given answers on conf.
+func (opt Options) apply(conf *configuration.Configuration) {
+	for _, a := range []struct {
+		v   string
+		dst *string
+	}{
+		{opt.EzbPki, &conf.EzbPki},
+		{opt.Listen, &conf.Listen},
+		{opt.ServiceName, &conf.ServiceName},
+		{opt.ServiceFullName, &conf.ServiceFullName},
+		{opt.LogLevel, &conf.LogLevel},
+		{opt.DB, &conf.DB},
+	} {
+		if a.v != "" {
+			*a.dst = a.v
+		}
+	}
+	if len(opt.SAN) > 0 {
+		conf.SAN = opt.SAN
+	}
+}
+
+// LoadAnswers read an answers file, the json names are the init flag names.
+func LoadAnswers(file string) (opt Options, err error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return opt, fail(ExitAnswers, "answers file: %s", err.Error())
+	}
+	if err := json.Unmarshal(raw, &opt); err != nil {
+		return opt, fail(ExitAnswers, "answers file %s: %s", file, err.Error())
+	}
+	opt.NonInteractive = true
+	return opt, nil
+}
+
+// copyFile copy a supplied certificate or key in the vault folder.
+func copyFile(src, dst string) error {
+	raw, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, raw, 0600)
+}
+
+// selfSigned write a one year ECDSA certificate for san, signed by itself and
+// also written as the CA. For development only.
+func selfSigned(name string, san []string, certFile, keyFile, caFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	tpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"ezBastion development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, s := range san {
+		if ip := net.ParseIP(s); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else {
+			tpl.DNSNames = append(tpl.DNSNames, s)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	for file, raw := range map[string][]byte{
+		certFile: cert,
+		caFile:   cert,
+		keyFile:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}),
+	} {
+		if err := ioutil.WriteFile(file, raw, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -16,6 +16,7 @@
 package setup
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -24,6 +25,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/ezbastion/ezb_lib/certmanager"
 	"github.com/ezbastion/ezb_lib/ez_stdio"
@@ -43,28 +45,28 @@ func init() {
 func CheckFolder() {
 
 	if _, err := os.Stat(path.Join(exPath, "cert")); os.IsNotExist(err) {
-		err = os.MkdirAll(path.Join(exPath, "cert"), 0600)
+		err = os.MkdirAll(path.Join(exPath, "cert"), 0700)
 		if err != nil {
 			return
 		}
 		logmanager.Info("Make cert folder.")
 	}
 	if _, err := os.Stat(path.Join(exPath, "log")); os.IsNotExist(err) {
-		err = os.MkdirAll(path.Join(exPath, "log"), 0600)
+		err = os.MkdirAll(path.Join(exPath, "log"), 0700)
 		if err != nil {
 			return
 		}
 		logmanager.Info("Make log folder.")
 	}
 	if _, err := os.Stat(path.Join(exPath, "conf")); os.IsNotExist(err) {
-		err = os.MkdirAll(path.Join(exPath, "conf"), 0600)
+		err = os.MkdirAll(path.Join(exPath, "conf"), 0700)
 		if err != nil {
 			return
 		}
 		logmanager.Info("Make conf folder.")
 	}
 	if _, err := os.Stat(path.Join(exPath, "db")); os.IsNotExist(err) {
-		err = os.MkdirAll(path.Join(exPath, "db"), 0600)
+		err = os.MkdirAll(path.Join(exPath, "db"), 0700)
 		if err != nil {
 			return
 		}
@@ -73,37 +75,63 @@ func CheckFolder() {
 }
 
 func Setup(isIntSess bool, firstcall bool) error {
+	return SetupWith(Options{}, firstcall)
+}
+
+// SetupWith run init with the answers of opt, the missing ones are asked
+// unless opt.NonInteractive. A failure is an *Error with the exit code.
+func SetupWith(opt Options, firstcall bool) error {
+	ex, _ := os.Executable()
+	return setupIn(filepath.Dir(ex), opt, firstcall)
+}
+
+// setupIn run init in the vault folder dir.
+func setupIn(dir string, opt Options, firstcall bool) error {
+	exPath = dir
 	CheckFolder()
 	logmanager.Debug("Entering in setup process")
-	ex, _ := os.Executable()
-	exPath = filepath.Dir(ex)
-	ConfFile := path.Join(exPath, "conf/config.json")
-	conf, err := configuration.CheckConfig(true, exPath)
+	ConfFile := configuration.ConfFile(exPath)
+	var conf configuration.Configuration
 	_fqdn := fqdn.Get()
 	hostname, _ := os.Hostname()
-	if err != nil {
-		conf.Listen = "0.0.0.0:5100"
-		conf.ServiceFullName = "ezBastion Vault"
-		conf.ServiceName = "ezb_vault"
-		conf.LogLevel = "debug"
-		conf.LogPath = ""
-		conf.CaCert = "cert/ca.crt"
-		conf.PrivateKey = "cert/ezb_vault.key"
-		conf.PublicCert = "cert/ezb_vault.crt"
-		conf.DB = "db/ezb_vault.db"
-		conf.EzbPki = "localhost:5010"
-		// conf.StaPath = ""
-		conf.JsonToStdout = false
-		conf.ReportCaller = false
-		conf.SAN = []string{_fqdn, hostname}
-	}
-
-	if firstcall {
+	conf.Listen = "0.0.0.0:5100"
+	conf.ServiceFullName = "ezBastion Vault"
+	conf.ServiceName = "ezb_vault"
+	conf.LogLevel = "debug"
+	conf.LogPath = ""
+	conf.CaCert = "cert/ca.crt"
+	conf.PrivateKey = "cert/ezb_vault.key"
+	conf.PublicCert = "cert/ezb_vault.crt"
+	conf.DB = "db/ezb_vault.db"
+	conf.EzbPki = "localhost:5010"
+	// conf.StaPath = ""
+	conf.JsonToStdout = false
+	conf.ReportCaller = false
+	conf.SAN = nil
+	for _, n := range []string{_fqdn, hostname} {
+		if n != "" && (len(conf.SAN) == 0 || conf.SAN[0] != n) {
+			conf.SAN = append(conf.SAN, n)
+		}
+	}
+	opt.apply(&conf)
+
+	// without config file, the EZB_VAULT_* variables may be enough to run
+	if _, err := os.Stat(ConfFile); err == nil && !firstcall {
+		if opt.NonInteractive {
+			fmt.Printf("%s already exists, nothing to do\n", ConfFile)
+		}
+		return nil
+	}
+	offline := opt.Offline || opt.SelfSigned
+
+	if !opt.NonInteractive {
 		fmt.Println("**************************")
 		fmt.Println("** EZB VAULT SETUP MODE **")
 		fmt.Println("**************************")
 		fmt.Println("Entering in the setup mode. Please answer the following requests ")
-		fmt.Println("\n")
+		fmt.Print("\n\n")
+	}
+	if !opt.NonInteractive && !offline {
 		fmt.Println("********************")
 		fmt.Println("*** PKI settings ***")
 		fmt.Println("********************")
@@ -127,15 +155,14 @@ func Setup(isIntSess bool, firstcall bool) error {
 				}
 			}
 		}
-		_fqdn := fqdn.Get()
-		hostname, _ := os.Hostname()
+	}
+	if !opt.NonInteractive {
 		fmt.Println("********************")
 		fmt.Println("*** SAN settings ***")
 		fmt.Println("********************")
 		fmt.Println("Certificat Subject Alternative Name.")
-		fmt.Println(fmt.Sprintf("By default using: <%s, %s> as SAN. Add more ?", _fqdn, hostname))
+		fmt.Println(fmt.Sprintf("By default using: <%s> as SAN. Add more ?", strings.Join(conf.SAN, ", ")))
 		for {
-			conf.SAN = []string{_fqdn, hostname}
 			tmp := conf.SAN
 
 			san := ez_stdio.AskForValue("SAN (comma separated list)", strings.Join(conf.SAN, ","), `(?m)^[[:ascii:]]*,?$`)
@@ -149,27 +176,21 @@ func Setup(isIntSess bool, firstcall bool) error {
 				break
 			}
 		}
+	}
+	if err := conf.Validate(); err != nil {
+		return fail(ExitAnswers, "%s", err.Error())
+	}
 
-		_, fica := os.Stat(path.Join(exPath, conf.CaCert))
-		logmanager.Debug(fmt.Sprintf("Cacert sets to %s", fica))
-		_, fipriv := os.Stat(path.Join(exPath, conf.PrivateKey))
-		logmanager.Debug(fmt.Sprintf("Privatekey sets to %s", fipriv))
-		_, fipub := os.Stat(path.Join(exPath, conf.PublicCert))
-		logmanager.Debug(fmt.Sprintf("PublicCert sets to %s", fipub))
-
-		if os.IsNotExist(fica) || os.IsNotExist(fipriv) || os.IsNotExist(fipub) {
-			logmanager.Debug("Setting the certificate")
-			keyFile := path.Join(exPath, conf.PrivateKey)
-			certFile := path.Join(exPath, conf.PublicCert)
-			caFile := path.Join(exPath, conf.CaCert)
-			request := certmanager.NewCertificateRequest(conf.ServiceName, 730, conf.SAN)
-			err = certmanager.Generate(request, conf.EzbPki, certFile, keyFile, caFile)
-			if err != nil {
-				logmanager.Fatal(fmt.Sprintf("generate certificat fatal error:\n%v", err))
-			}
-			logmanager.Debug("Certificate generated")
+	if err := setupCert(conf, opt); err != nil {
+		return err
+	}
+	for _, sta := range opt.Sta {
+		if err := copyFile(sta, path.Join(exPath, "cert", filepath.Base(sta))); err != nil {
+			return fail(ExitWrite, "sta certificate: %s", err.Error())
 		}
+	}
 
+	if len(opt.Sta) == 0 {
 		// We set the sta path by mandatory to cert
 		// conf.StaPath = path.Join(exPath, "cert")
 		fmt.Println("********************************")
@@ -203,11 +224,68 @@ func Setup(isIntSess bool, firstcall bool) error {
 		// 		break
 		// 	}
 		// }
+	}
+
+	c, _ := json.Marshal(conf)
+	if err := ioutil.WriteFile(ConfFile, c, 0600); err != nil {
+		return fail(ExitWrite, "%s", err.Error())
+	}
+	logmanager.Debug(fmt.Sprintf("%s saved", ConfFile))
+	if opt.NonInteractive {
+		fmt.Printf("%s saved\n", ConfFile)
+	}
+	return nil
+}
 
-		c, _ := json.Marshal(conf)
-		ioutil.WriteFile(ConfFile, c, 0600)
-		logmanager.Debug(fmt.Sprintf("%s saved", ConfFile))
+// setupCert put the certificate pair and the CA in place: copied from the
+// supplied files, already there, self-signed or issued by ezb_pki.
+func setupCert(conf configuration.Configuration, opt Options) error {
+	keyFile := path.Join(exPath, conf.PrivateKey)
+	certFile := path.Join(exPath, conf.PublicCert)
+	caFile := path.Join(exPath, conf.CaCert)
+	for _, f := range [][2]string{{opt.Cert, certFile}, {opt.Key, keyFile}, {opt.CA, caFile}} {
+		if f[0] == "" {
+			continue
+		}
+		if err := copyFile(f[0], f[1]); err != nil {
+			return fail(ExitCert, "%s", err.Error())
+		}
 	}
 
+	_, fica := os.Stat(caFile)
+	logmanager.Debug(fmt.Sprintf("Cacert sets to %s", fica))
+	_, fipriv := os.Stat(keyFile)
+	logmanager.Debug(fmt.Sprintf("Privatekey sets to %s", fipriv))
+	_, fipub := os.Stat(certFile)
+	logmanager.Debug(fmt.Sprintf("PublicCert sets to %s", fipub))
+
+	if os.IsNotExist(fica) || os.IsNotExist(fipriv) || os.IsNotExist(fipub) {
+		logmanager.Debug("Setting the certificate")
+		switch {
+		case opt.SelfSigned:
+			if err := selfSigned(conf.ServiceName, conf.SAN, certFile, keyFile, caFile); err != nil {
+				return fail(ExitWrite, "self-signed certificate: %s", err.Error())
+			}
+			logmanager.Warning("Self-signed certificate generated, for development only")
+		case opt.Offline:
+			return fail(ExitCert, "offline: %s, %s and %s are required", conf.PublicCert, conf.PrivateKey, conf.CaCert)
+		default:
+			if opt.NonInteractive {
+				conn, err := net.DialTimeout("tcp", conf.EzbPki, 10*time.Second)
+				if err != nil {
+					return fail(ExitPKI, "failed to connect to ezb_pki %s: %s", conf.EzbPki, err.Error())
+				}
+				conn.Close()
+			}
+			request := certmanager.NewCertificateRequest(conf.ServiceName, 730, conf.SAN)
+			if err := certmanager.Generate(request, conf.EzbPki, certFile, keyFile, caFile); err != nil {
+				return fail(ExitPKI, "generate certificat error: %v", err)
+			}
+		}
+		logmanager.Debug("Certificate generated")
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return fail(ExitCert, "%s", err.Error())
+	}
 	return nil
 }
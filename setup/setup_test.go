@@ -0,0 +1,195 @@
+// This file is part of ezBastion.
+
+//     ezBastion is free software: you can redistribute it and/or modify
+//     it under the terms of the GNU Affero General Public License as published by
+//     the Free Software Foundation, either version 3 of the License, or
+//     (at your option) any later version.
+
+//     ezBastion is distributed in the hope that it will be useful,
+//     but WITHOUT ANY WARRANTY; without even the implied warranty of
+//     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//     GNU Affero General Public License for more details.
+
+//     You should have received a copy of the GNU Affero General Public License
+//     along with ezBastion.  If not, see <https://www.gnu.org/licenses/>.
+
+package setup
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ezbastion/ezb_vault/configuration"
+)
+
+// testDir return a new vault folder, EZB_VAULT_CONFIG unset so config.json is
+// written in it.
+func testDir(t *testing.T) string {
+	t.Setenv(configuration.EnvPrefix+"CONFIG", "")
+	dir, err := ioutil.TempDir("", "setup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// readConf return the config.json written by init.
+func readConf(t *testing.T, dir string) configuration.Configuration {
+	var conf configuration.Configuration
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "conf", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(raw, &conf); err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+func TestLoadAnswers(t *testing.T) {
+	dir := testDir(t)
+	file := filepath.Join(dir, "answers.json")
+	ioutil.WriteFile(file, []byte(`{"offline":true,"san":["vault.local"],"listen":"0.0.0.0:5200","dbpath":"db/test.db","sta":["ezb_sta.crt"]}`), 0600)
+	opt, err := LoadAnswers(file)
+	if err != nil {
+		t.Fatalf("TestLoadAnswers was incorrect, got: <%v>, want: <%v>.", err, nil)
+	}
+	if !opt.NonInteractive || !opt.Offline || opt.Listen != "0.0.0.0:5200" || opt.DB != "db/test.db" || len(opt.SAN) != 1 || len(opt.Sta) != 1 {
+		t.Errorf("TestLoadAnswers was incorrect, got: <%+v>.", opt)
+	}
+	ioutil.WriteFile(file, []byte(`{"listen":`), 0600)
+	for _, f := range []string{file, filepath.Join(dir, "missing.json")} {
+		if _, err := LoadAnswers(f); exitCode(err) != ExitAnswers {
+			t.Errorf("TestLoadAnswers %s was incorrect, got: <%v>, want exit code: <%d>.", filepath.Base(f), err, ExitAnswers)
+		}
+	}
+}
+
+// exitCode return the exit code of an init failure, 0 without error.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return 1
+}
+
+func TestSelfSigned(t *testing.T) {
+	dir := testDir(t)
+	opt := Options{NonInteractive: true, SelfSigned: true, SAN: []string{"vault.local", "127.0.0.1"}, Listen: "127.0.0.1:5200"}
+	if err := setupIn(dir, opt, true); err != nil {
+		t.Fatalf("TestSelfSigned was incorrect, got: <%v>, want: <%v>.", err, nil)
+	}
+	conf := readConf(t, dir)
+	if conf.Listen != "127.0.0.1:5200" || len(conf.SAN) != 2 || conf.SAN[0] != "vault.local" {
+		t.Errorf("TestSelfSigned config was incorrect, got: <%+v>.", conf)
+	}
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, conf.PublicCert), filepath.Join(dir, conf.PrivateKey))
+	if err != nil {
+		t.Fatalf("TestSelfSigned pair was incorrect, got: <%v>, want: <%v>.", err, nil)
+	}
+	cert, _ := x509.ParseCertificate(pair.Certificate[0])
+	if err := cert.VerifyHostname("vault.local"); err != nil {
+		t.Errorf("TestSelfSigned name was incorrect, got: <%v>, want: <%v>.", err, nil)
+	}
+	if err := cert.VerifyHostname("127.0.0.1"); err != nil {
+		t.Errorf("TestSelfSigned ip was incorrect, got: <%v>, want: <%v>.", err, nil)
+	}
+	raw, _ := ioutil.ReadFile(filepath.Join(dir, conf.CaCert))
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		t.Fatalf("TestSelfSigned CA was incorrect, got: <%s>, want a certificate.", raw)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "vault.local", Roots: pool}); err != nil {
+		t.Errorf("TestSelfSigned verify was incorrect, got: <%v>, want: <%v>.", err, nil)
+	}
+	// config.json is there, a second init keep it
+	if err := setupIn(dir, Options{NonInteractive: true, Listen: "0.0.0.0:5300"}, false); err != nil || readConf(t, dir).Listen != "127.0.0.1:5200" {
+		t.Errorf("TestSelfSigned again was incorrect, got: <%v %s>, want: <%v %s>.", err, readConf(t, dir).Listen, nil, "127.0.0.1:5200")
+	}
+}
+
+// --offline copy the supplied certificates and never call ezb_pki.
+func TestOffline(t *testing.T) {
+	src := testDir(t)
+	certFile, keyFile, caFile := filepath.Join(src, "vault.crt"), filepath.Join(src, "vault.key"), filepath.Join(src, "ca.crt")
+	if err := selfSigned("ezb_vault", []string{"vault.local"}, certFile, keyFile, caFile); err != nil {
+		t.Fatal(err)
+	}
+	sta := filepath.Join(src, "ezb_sta.crt")
+	ioutil.WriteFile(sta, []byte("sta"), 0600)
+
+	dir := testDir(t)
+	opt := Options{NonInteractive: true, Offline: true, EzbPki: "127.0.0.1:1", SAN: []string{"vault.local"}, Cert: certFile, Key: keyFile, CA: caFile, Sta: []string{sta}}
+	if err := setupIn(dir, opt, true); err != nil {
+		t.Fatalf("TestOffline was incorrect, got: <%v>, want: <%v>.", err, nil)
+	}
+	conf := readConf(t, dir)
+	for _, f := range [][2]string{{certFile, conf.PublicCert}, {keyFile, conf.PrivateKey}, {caFile, conf.CaCert}, {sta, "cert/ezb_sta.crt"}} {
+		want, _ := ioutil.ReadFile(f[0])
+		got, err := ioutil.ReadFile(filepath.Join(dir, f[1]))
+		if err != nil || string(got) != string(want) {
+			t.Errorf("TestOffline %s was incorrect, got: <%v>, want a copy of %s.", f[1], err, f[0])
+		}
+	}
+	if conf.EzbPki != "127.0.0.1:1" {
+		t.Errorf("TestOffline pki was incorrect, got: <%s>, want: <%s>.", conf.EzbPki, "127.0.0.1:1")
+	}
+}
+
+// the documented exit codes of a failed init.
+func TestExitCodes(t *testing.T) {
+	src := testDir(t)
+	certFile, keyFile, caFile := filepath.Join(src, "vault.crt"), filepath.Join(src, "vault.key"), filepath.Join(src, "ca.crt")
+	if err := selfSigned("ezb_vault", []string{"vault.local"}, certFile, keyFile, caFile); err != nil {
+		t.Fatal(err)
+	}
+	other := testDir(t)
+	otherKey := filepath.Join(other, "vault.key")
+	if err := selfSigned("other", []string{"other.local"}, filepath.Join(other, "vault.crt"), otherKey, filepath.Join(other, "ca.crt")); err != nil {
+		t.Fatal(err)
+	}
+	notPEM := filepath.Join(src, "not.pem")
+	ioutil.WriteFile(notPEM, pem.EncodeToMemory(&pem.Block{Type: "NOTHING", Bytes: []byte("x")}), 0600)
+
+	for _, tt := range []struct {
+		name string
+		opt  Options
+		code int
+	}{
+		{"listen", Options{SelfSigned: true, Listen: "0.0.0.0"}, ExitAnswers},
+		{"loglevel", Options{SelfSigned: true, LogLevel: "verbose"}, ExitAnswers},
+		{"pki", Options{EzbPki: "127.0.0.1:1"}, ExitPKI},
+		{"offline", Options{Offline: true}, ExitCert},
+		{"missing cert", Options{Offline: true, Cert: filepath.Join(src, "missing.crt"), Key: keyFile, CA: caFile}, ExitCert},
+		{"other key", Options{Offline: true, Cert: certFile, Key: otherKey, CA: caFile}, ExitCert},
+		{"not a cert", Options{Offline: true, Cert: notPEM, Key: keyFile, CA: caFile}, ExitCert},
+		{"sta", Options{SelfSigned: true, Sta: []string{filepath.Join(src, "missing_sta.crt")}}, ExitWrite},
+	} {
+		dir := testDir(t)
+		tt.opt.NonInteractive = true
+		err := setupIn(dir, tt.opt, true)
+		if exitCode(err) != tt.code {
+			t.Errorf("TestExitCodes %s was incorrect, got: <%v>, want exit code: <%d>.", tt.name, err, tt.code)
+		}
+		if _, serr := os.Stat(filepath.Join(dir, "conf", "config.json")); !os.IsNotExist(serr) {
+			t.Errorf("TestExitCodes %s was incorrect, got config.json written, want none.", tt.name)
+		}
+	}
+
+	// conf is not a folder, config.json can not be written
+	dir := testDir(t)
+	ioutil.WriteFile(filepath.Join(dir, "conf"), nil, 0600)
+	if err := setupIn(dir, Options{NonInteractive: true, SelfSigned: true}, true); exitCode(err) != ExitWrite {
+		t.Errorf("TestExitCodes config was incorrect, got: <%v>, want exit code: <%d>.", err, ExitWrite)
+	}
+}